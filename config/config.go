@@ -1,9 +1,31 @@
 package config
 
 type config struct {
-	DB DBConfig
+	DB     DBConfig
+	Broker BrokerConfig
+	WS     WSConfig
 }
 
 type DBConfig struct {
 	DSN string
 }
+
+const (
+	BrokerMemory = "memory"
+	BrokerRedis  = "redis"
+)
+
+// BrokerConfig selects how the board events are shared between the
+// instances. "memory" is enough for a single instance.
+type BrokerConfig struct {
+	Type      string
+	RedisAddr string
+}
+
+// WSConfig limits what a WebSocket client can send.
+type WSConfig struct {
+	MaxMessageSize int64
+	// messages per second per connection
+	RateLimit float64
+	RateBurst int
+}
@@ -6,4 +6,13 @@ var Config = config{
 	DB: DBConfig{
 		DSN: "root:root@tcp(localhost:3336)/qooldown?parseTime=True",
 	},
+	Broker: BrokerConfig{
+		Type:      BrokerMemory,
+		RedisAddr: "localhost:6379",
+	},
+	WS: WSConfig{
+		MaxMessageSize: 4096,
+		RateLimit:      20,
+		RateBurst:      40,
+	},
 }
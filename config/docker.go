@@ -6,4 +6,13 @@ var Config = config{
 	DB: DBConfig{
 		DSN: "root:root@tcp(mysql:3306)/qooldown?parseTime=true",
 	},
+	Broker: BrokerConfig{
+		Type:      BrokerMemory,
+		RedisAddr: "redis:6379",
+	},
+	WS: WSConfig{
+		MaxMessageSize: 4096,
+		RateLimit:      20,
+		RateBurst:      40,
+	},
 }
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	"github.com/chenmuyao/qooldown/internal/service"
+)
+
+// BrokerMessage is what the instances share about a retro: either a board
+// event, numbered in the retro stream, an ephemeral message relayed from a
+// client, or the participants connected to an instance.
+type BrokerMessage struct {
+	RetroID int64           `json:"retro_id"`
+	Seq     int64           `json:"seq"`
+	Event   *service.Event  `json:"event"`
+	Relay   *WsJSONResponse `json:"relay"`
+	// the participants of an instance
+	Presence *PresenceUpdate `json:"presence"`
+	// not relayed back to the connections of this user, if set
+	SenderID int64 `json:"sender_id"`
+	// changes when the numbering of Seq starts over, so that the numbers of
+	// before are not taken for the new ones
+	Epoch string `json:"epoch"`
+}
+
+// Broker carries the retro messages between the Qooldown instances, so that
+// the clients of a retro get them whatever instance they are connected to.
+type Broker interface {
+	// Publish numbers the message if it is an event, and sends it to the
+	// subscribers of every instance.
+	Publish(ctx context.Context, msg BrokerMessage) error
+	// Subscribe returns the messages of every instance, in the order of their
+	// sequence numbers.
+	Subscribe(ctx context.Context) (<-chan BrokerMessage, error)
+}
+
+// {{{ Memory
+
+// memoryBroker is a broker for a single instance. The numbering starts over
+// with the process.
+type memoryBroker struct {
+	mu    sync.Mutex
+	epoch string
+	seqs  map[int64]int64
+	msgs  chan BrokerMessage
+}
+
+func NewMemoryBroker() Broker {
+	return &memoryBroker{
+		epoch: randomID(),
+		seqs:  make(map[int64]int64),
+		msgs:  make(chan BrokerMessage, eventQueueSize),
+	}
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, msg BrokerMessage) error {
+	// NOTE: keep the lock while sending, so that the messages are in order
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if msg.Event != nil {
+		b.seqs[msg.RetroID]++
+		msg.Seq = b.seqs[msg.RetroID]
+		msg.Epoch = b.epoch
+	}
+
+	select {
+	case b.msgs <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *memoryBroker) Subscribe(ctx context.Context) (<-chan BrokerMessage, error) {
+	return b.msgs, nil
+}
+
+// }}}
+
+// randomID returns a random ID, for an epoch of the events or an instance.
+func randomID() string {
+	b := make([]byte, 8)
+	// never fails
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisChannel     = "qooldown:retros"
+	redisSeqKeyFmt   = "qooldown:retro:%d:seq"
+	redisEpochKeyFmt = "qooldown:retro:%d:epoch"
+)
+
+// publishScript numbers the message and publishes it atomically, so that the
+// messages are received in the order of their sequence numbers. A new epoch
+// is set when the numbering starts over, if the keys were lost for example.
+//
+// The payload is "<seq> <epoch> <json>", as the JSON is not decoded in the
+// script.
+var publishScript = redis.NewScript(`
+local seq = 0
+local epoch = ""
+if ARGV[1] == "1" then
+	seq = redis.call("INCR", KEYS[1])
+	epoch = redis.call("GET", KEYS[2])
+	if seq == 1 or not epoch then
+		epoch = ARGV[4]
+		redis.call("SET", KEYS[2], epoch)
+	end
+end
+redis.call("PUBLISH", ARGV[2], seq .. " " .. epoch .. " " .. ARGV[3])
+return seq
+`)
+
+// redisBroker shares the messages through Redis pub/sub, for several
+// instances behind a load balancer.
+type redisBroker struct {
+	client redis.UniversalClient
+}
+
+func NewRedisBroker(client redis.UniversalClient) Broker {
+	return &redisBroker{
+		client: client,
+	}
+}
+
+func (b *redisBroker) Publish(ctx context.Context, msg BrokerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	numbered := "0"
+	if msg.Event != nil {
+		numbered = "1"
+	}
+
+	return publishScript.Run(
+		ctx,
+		b.client,
+		[]string{
+			fmt.Sprintf(redisSeqKeyFmt, msg.RetroID),
+			fmt.Sprintf(redisEpochKeyFmt, msg.RetroID),
+		},
+		numbered,
+		redisChannel,
+		data,
+		randomID(),
+	).Err()
+}
+
+func (b *redisBroker) Subscribe(ctx context.Context) (<-chan BrokerMessage, error) {
+	pubsub := b.client.Subscribe(ctx, redisChannel)
+	// wait for the confirmation to not miss the first messages
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = pubsub.Close()
+	}()
+
+	msgs := make(chan BrokerMessage, eventQueueSize)
+	go func() {
+		defer close(msgs)
+
+		// the channel is closed when the pubsub is closed
+		for m := range pubsub.Channel() {
+			msg, err := decodeRedisPayload(m.Payload)
+			if err != nil {
+				slog.Error("redis broker decode", "payload", m.Payload, "err", err)
+				continue
+			}
+
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return msgs, nil
+}
+
+func decodeRedisPayload(payload string) (BrokerMessage, error) {
+	seqStr, rest, ok := strings.Cut(payload, " ")
+	if !ok {
+		return BrokerMessage{}, fmt.Errorf("malformed payload")
+	}
+	epoch, data, ok := strings.Cut(rest, " ")
+	if !ok {
+		return BrokerMessage{}, fmt.Errorf("malformed payload")
+	}
+
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if err != nil {
+		return BrokerMessage{}, err
+	}
+
+	var msg BrokerMessage
+	err = json.Unmarshal([]byte(data), &msg)
+	if err != nil {
+		return BrokerMessage{}, err
+	}
+
+	msg.Seq = seq
+	msg.Epoch = epoch
+	return msg, nil
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chenmuyao/qooldown/internal/service"
+	"github.com/redis/go-redis/v9"
+)
+
+const testTimeout = 2 * time.Second
+
+func newTestRedisBroker(t *testing.T, mr *miniredis.Miniredis) Broker {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return NewRedisBroker(client)
+}
+
+func subscribe(t *testing.T, b Broker) <-chan BrokerMessage {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	msgs, err := b.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	return msgs
+}
+
+func receiveMessage(t *testing.T, msgs <-chan BrokerMessage) BrokerMessage {
+	t.Helper()
+	select {
+	case msg := <-msgs:
+		return msg
+	case <-time.After(testTimeout):
+		t.Fatal("no message from the broker")
+		return BrokerMessage{}
+	}
+}
+
+func publishEvent(t *testing.T, b Broker, rid int64) {
+	t.Helper()
+	err := b.Publish(context.Background(), BrokerMessage{
+		RetroID: rid,
+		Event:   &service.Event{Type: service.EventPostitCreated, RetroID: rid},
+	})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+}
+
+func TestRedisBrokerNumbersEventsByRetro(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestRedisBroker(t, mr)
+	b := newTestRedisBroker(t, mr)
+	fromA := subscribe(t, a)
+	fromB := subscribe(t, b)
+
+	publishEvent(t, a, 1)
+	publishEvent(t, b, 1)
+	publishEvent(t, a, 2)
+	err := b.Publish(context.Background(), BrokerMessage{
+		RetroID:  1,
+		SenderID: 3,
+		Relay:    &WsJSONResponse{Action: ActionTypingStart, RetroID: 1},
+	})
+	if err != nil {
+		t.Fatalf("publish relay: %v", err)
+	}
+
+	want := []struct {
+		retroID int64
+		seq     int64
+		event   bool
+	}{
+		{1, 1, true},
+		{1, 2, true},
+		{2, 1, true},
+		{1, 0, false},
+	}
+	// every instance gets every message, in order
+	for _, msgs := range []<-chan BrokerMessage{fromA, fromB} {
+		var epoch string
+		for i, w := range want {
+			msg := receiveMessage(t, msgs)
+			if msg.RetroID != w.retroID || msg.Seq != w.seq || (msg.Event != nil) != w.event {
+				t.Fatalf("message %d: got retro %d seq %d event %v, want %+v",
+					i, msg.RetroID, msg.Seq, msg.Event != nil, w)
+			}
+			if !w.event {
+				if msg.Epoch != "" || msg.Relay == nil || msg.SenderID != 3 {
+					t.Fatalf("relay: got %+v", msg)
+				}
+				continue
+			}
+			if msg.Epoch == "" {
+				t.Fatalf("message %d: no epoch", i)
+			}
+			if w.retroID == 1 && epoch != "" && msg.Epoch != epoch {
+				t.Fatalf("message %d: epoch changed from %q to %q", i, epoch, msg.Epoch)
+			}
+			if w.retroID == 1 {
+				epoch = msg.Epoch
+			}
+		}
+	}
+}
+
+func TestRedisBrokerNewEpochWhenNumberingRestarts(t *testing.T) {
+	mr := miniredis.RunT(t)
+	b := newTestRedisBroker(t, mr)
+	msgs := subscribe(t, b)
+
+	publishEvent(t, b, 1)
+	publishEvent(t, b, 1)
+	receiveMessage(t, msgs)
+	before := receiveMessage(t, msgs)
+
+	// the keys are lost, with a restart of Redis for example
+	mr.FlushAll()
+
+	publishEvent(t, b, 1)
+	after := receiveMessage(t, msgs)
+	if after.Seq != 1 {
+		t.Fatalf("got seq %d after the restart, want 1", after.Seq)
+	}
+	if after.Epoch == before.Epoch {
+		t.Fatalf("epoch %q kept after the numbering restarted", after.Epoch)
+	}
+
+	s := &retroStream{}
+	s.append(before.Seq, before.Epoch, *before.Event)
+	s.append(after.Seq, after.Epoch, *after.Event)
+	if _, ok := s.since(before.Epoch, 1); ok {
+		t.Fatal("resumed from a sequence number of the previous epoch")
+	}
+	if events, ok := s.since(after.Epoch, 0); !ok || len(events) != 1 {
+		t.Fatalf("got %d events, %v, want the event of the new epoch", len(events), ok)
+	}
+}
+
+// runHub starts the hub and waits until it listens to the broker.
+func runHub(t *testing.T, b Broker) *WsHub {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	hub := NewWsHub(b)
+	go func() {
+		_ = hub.Run(ctx)
+	}()
+	// answered once subscribed
+	hub.Participants(0)
+	return hub
+}
+
+// receiveAction returns the next response of the client with the action,
+// skipping the others.
+func receiveAction(t *testing.T, c *wsClient, action string) WsJSONResponse {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case r, ok := <-c.send:
+			if !ok {
+				t.Fatalf("client dropped while waiting for %s", action)
+			}
+			if r.Action == action {
+				return r
+			}
+		case <-timeout:
+			t.Fatalf("no %s", action)
+		}
+	}
+}
+
+// waitParticipants waits until the hub sees the users in the retro.
+func waitParticipants(t *testing.T, hub *WsHub, rid int64, uids ...int64) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		got := hub.Participants(rid)
+		if len(got) == len(uids) {
+			found := 0
+			for _, p := range got {
+				for _, uid := range uids {
+					if p.UID == uid {
+						found++
+					}
+				}
+			}
+			if found == len(uids) {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got participants %+v, want %v", got, uids)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisBrokerSharesPresence(t *testing.T) {
+	mr := miniredis.RunT(t)
+	hubA := runHub(t, newTestRedisBroker(t, mr))
+	hubB := runHub(t, newTestRedisBroker(t, mr))
+
+	bob := newWsClient(nil, 1, 2, "bob")
+	hubB.register <- bob
+	receiveAction(t, bob, ActionParticipantJoined)
+
+	alice := newWsClient(nil, 1, 1, "alice")
+	hubA.register <- alice
+
+	// bob is told by the other instance
+	joined := receiveAction(t, bob, ActionParticipantJoined)
+	if joined.Participant.UID != 1 {
+		t.Fatalf("got %+v joined, want alice", joined.Participant)
+	}
+	waitParticipants(t, hubA, 1, 1, 2)
+	waitParticipants(t, hubB, 1, 1, 2)
+
+	// a second tab is not a new participant
+	aliceTab := newWsClient(nil, 1, 1, "alice")
+	hubB.register <- aliceTab
+	hubA.unregister <- alice
+	waitParticipants(t, hubB, 1, 1, 2)
+
+	hubB.unregister <- aliceTab
+	left := receiveAction(t, bob, ActionParticipantLeft)
+	if left.Participant.UID != 1 {
+		t.Fatalf("got %+v left, want alice", left.Participant)
+	}
+	waitParticipants(t, hubA, 1, 2)
+	waitParticipants(t, hubB, 1, 2)
+}
+
+func TestPresenceOfAnInstanceExpires(t *testing.T) {
+	// not running, the test owns the hub
+	hub := NewWsHub(NewMemoryBroker())
+	now := time.Now()
+	alice := Participant{UID: 1, Username: "alice", ConnectedSince: now, LastActive: now}
+
+	hub.updatePresence(1, PresenceUpdate{
+		Instance:     "other",
+		Version:      2,
+		Participants: []Participant{alice},
+	})
+	// late update
+	hub.updatePresence(1, PresenceUpdate{Instance: "other", Version: 1})
+	if got := hub.participants(1); len(got) != 1 || got[0].UID != 1 {
+		t.Fatalf("got participants %+v, want alice", got)
+	}
+
+	hub.refreshPresence(now.Add(presenceTTL / 2))
+	if got := hub.participants(1); len(got) != 1 {
+		t.Fatalf("got participants %+v before the TTL, want alice", got)
+	}
+	hub.refreshPresence(now.Add(presenceTTL + time.Second))
+	if got := hub.participants(1); len(got) != 0 {
+		t.Fatalf("got participants %+v after the TTL, want none", got)
+	}
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/chenmuyao/qooldown/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// GetComments returns the discussion on a post-it
+func (h *RetroHandler) GetComments(ctx *gin.Context) {
+	pid, ok := commentPostitID(ctx)
+	if !ok {
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	comments, err := h.svc.GetComments(ctx, pid, uid.(int64))
+	commentResult(ctx, err, comments, "get comments success")
+}
+
+// CreateComment adds a comment on a post-it
+func (h *RetroHandler) CreateComment(ctx *gin.Context) {
+	type Req struct {
+		Body string `json:"body" binding:"required"`
+	}
+
+	pid, ok := commentPostitID(ctx)
+	if !ok {
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	c, err := h.svc.CreateComment(ctx, pid, req.Body, uid.(int64))
+	commentResult(ctx, err, c, "create comment success")
+}
+
+// UpdateComment changes the body of a comment of the user
+func (h *RetroHandler) UpdateComment(ctx *gin.Context) {
+	type Req struct {
+		Body string `json:"body" binding:"required"`
+	}
+
+	pid, ok := commentPostitID(ctx)
+	if !ok {
+		return
+	}
+	cid, ok := commentID(ctx)
+	if !ok {
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	c, err := h.svc.UpdateComment(ctx, pid, cid, req.Body, uid.(int64))
+	commentResult(ctx, err, c, "update comment success")
+}
+
+// DeleteCommentByID deletes a comment of the user
+func (h *RetroHandler) DeleteCommentByID(ctx *gin.Context) {
+	pid, ok := commentPostitID(ctx)
+	if !ok {
+		return
+	}
+	cid, ok := commentID(ctx)
+	if !ok {
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err := h.svc.DeleteCommentByID(ctx, pid, cid, uid.(int64))
+	commentResult(ctx, err, nil, "delete comment success")
+}
+
+// commentPostitID gets the post-it ID of the path, or answers a bad request.
+func commentPostitID(ctx *gin.Context) (int64, bool) {
+	idStr := ctx.Param("id")
+
+	pid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong postit id", "id", idStr, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong postit id",
+		})
+		return 0, false
+	}
+	return int64(pid), true
+}
+
+// commentID gets the comment ID of the path, or answers a bad request.
+func commentID(ctx *gin.Context) (int64, bool) {
+	idStr := ctx.Param("cid")
+
+	cid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong comment id", "id", idStr, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong comment id",
+		})
+		return 0, false
+	}
+	return int64(cid), true
+}
+
+// commentResult answers a request on the comments.
+func commentResult(ctx *gin.Context, err error, data any, msg string) {
+	switch err {
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+	case service.ErrWrongPhase:
+		slog.Error("wrong phase", "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+	case service.ErrIDNotFound:
+		slog.Error("id not found", "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  msg,
+			Data: data,
+		})
+	default:
+		slog.Error("comment", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+	}
+}
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/chenmuyao/qooldown/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateGroup groups post-its of a question
+func (h *RetroHandler) CreateGroup(ctx *gin.Context) {
+	var req service.GroupCreate
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	g, err := h.svc.CreateGroup(ctx, req, uid.(int64))
+	groupResult(ctx, err, g, "create group success")
+}
+
+// RenameGroup changes the title of the group
+func (h *RetroHandler) RenameGroup(ctx *gin.Context) {
+	type Req struct {
+		Title string `json:"title"`
+	}
+
+	gid, ok := groupID(ctx)
+	if !ok {
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	g, err := h.svc.RenameGroup(ctx, gid, req.Title, uid.(int64))
+	groupResult(ctx, err, g, "rename group success")
+}
+
+// DissolveGroup deletes the group, its post-its are kept
+func (h *RetroHandler) DissolveGroup(ctx *gin.Context) {
+	gid, ok := groupID(ctx)
+	if !ok {
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err := h.svc.DissolveGroup(ctx, gid, uid.(int64))
+	groupResult(ctx, err, nil, "dissolve group success")
+}
+
+// AddPostitToGroup moves a post-it into the group
+func (h *RetroHandler) AddPostitToGroup(ctx *gin.Context) {
+	type Req struct {
+		PostitID int64 `json:"postit_id" binding:"required"`
+	}
+
+	gid, ok := groupID(ctx)
+	if !ok {
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	g, err := h.svc.AddPostitToGroup(ctx, gid, req.PostitID, uid.(int64))
+	groupResult(ctx, err, g, "add postit to group success")
+}
+
+// RemovePostitFromGroup takes a post-it out of the group
+func (h *RetroHandler) RemovePostitFromGroup(ctx *gin.Context) {
+	gid, ok := groupID(ctx)
+	if !ok {
+		return
+	}
+
+	pidStr := ctx.Param("pid")
+
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		slog.Error("wrong postit id", "id", pidStr, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong postit id",
+		})
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	g, err := h.svc.RemovePostitFromGroup(ctx, gid, int64(pid), uid.(int64))
+	groupResult(ctx, err, g, "remove postit from group success")
+}
+
+// VoteGroupByID votes for the group as a whole
+func (h *RetroHandler) VoteGroupByID(ctx *gin.Context) {
+	gid, ok := groupID(ctx)
+	if !ok {
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err := h.svc.VoteGroupByID(ctx, gid, uid.(int64))
+	groupResult(ctx, err, nil, "vote for group success")
+}
+
+// UnvoteGroupByID takes back one vote of the user on the group
+func (h *RetroHandler) UnvoteGroupByID(ctx *gin.Context) {
+	gid, ok := groupID(ctx)
+	if !ok {
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err := h.svc.UnvoteGroupByID(ctx, gid, uid.(int64))
+	groupResult(ctx, err, nil, "unvote for group success")
+}
+
+// groupID gets the group ID of the path, or answers a bad request.
+func groupID(ctx *gin.Context) (int64, bool) {
+	idStr := ctx.Param("id")
+
+	gid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong group id", "id", idStr, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong group id",
+		})
+		return 0, false
+	}
+	return int64(gid), true
+}
+
+// groupResult answers a change of a group.
+func groupResult(ctx *gin.Context, err error, data any, msg string) {
+	switch err {
+	case service.ErrNoAccess, service.ErrSelfVote:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+	case service.ErrWrongPhase,
+		service.ErrNoVotesLeft,
+		service.ErrPostitVoteLimit,
+		service.ErrNoVote:
+		slog.Error("cannot change group", "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+	case service.ErrWrongQuestion, service.ErrNotInGroup:
+		slog.Error("wrong postit", "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+	case service.ErrIDNotFound:
+		slog.Error("id not found", "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  msg,
+			Data: data,
+		})
+	default:
+		slog.Error("change group", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+	}
+}
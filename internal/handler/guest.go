@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chenmuyao/qooldown/internal/repository"
+	"github.com/chenmuyao/qooldown/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// GuestKey signs the tokens of the guests, so that they cannot be used as
+// login tokens.
+var GuestKey = []byte("c4Hq8NvZ1tRbW6yKp3LmX9sDf2JgA7eU")
+
+// GuestLinkKey signs the share links for the guests, so that neither the
+// invite links of the members nor the other tokens let anyone join as a guest.
+var GuestLinkKey = []byte("Wn5pQ8cLx2VtR7mKd4HsY9bFj3GzA6eT")
+
+const (
+	guestTTL     = 12 * time.Hour
+	guestLinkTTL = 24 * time.Hour
+)
+
+// GuestLinkClaims is the content of a share link, to join a retro as a guest.
+type GuestLinkClaims struct {
+	jwt.RegisteredClaims
+	RetroID int64
+}
+
+// GuestClaims is the token of a guest, only valid for a single retro.
+type GuestClaims struct {
+	UserClaims
+	RetroID int64
+}
+
+// GuestRoutes are the routes a guest may use, on its retro only. The others
+// need an account.
+var GuestRoutes = []string{
+	"GET /retros/:id",
+	"GET /retros/:id/top",
+	"GET /retros/:id/my-votes",
+	"GET /retros/:id/participants",
+	"GET /retros/:id/events",
+	"POST /postits/",
+	"POST /postits/:id",
+	"DELETE /postits/:id",
+	"POST /postits/:id/vote",
+	"DELETE /postits/:id/vote",
+	"POST /postits/:id/position",
+	"GET /postits/:id/comments",
+	"POST /postits/:id/comments",
+	"POST /postits/:id/comments/:cid",
+	"DELETE /postits/:id/comments/:cid",
+	"POST /groups/",
+	"POST /groups/:id",
+	"DELETE /groups/:id",
+	"POST /groups/:id/postits",
+	"DELETE /groups/:id/postits/:pid",
+	"POST /groups/:id/vote",
+	"DELETE /groups/:id/vote",
+}
+
+// ParseGuestToken parses the token of a guest.
+func ParseGuestToken(tokenStr string) (GuestClaims, error) {
+	var gc GuestClaims
+	token, err := jwt.ParseWithClaims(tokenStr, &gc, func(t *jwt.Token) (interface{}, error) {
+		return GuestKey, nil
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		return GuestClaims{}, err
+	}
+
+	if !token.Valid {
+		return GuestClaims{}, jwt.ErrTokenInvalidClaims
+	}
+
+	return gc, nil
+}
+
+type guestData struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	ID       int64  `json:"id"`
+	RetroID  int64  `json:"retro_id"`
+}
+
+// CreateGuestLink returns a token that lets visitors without an account join
+// the retro as guests
+func (h *RetroHandler) CreateGuestLink(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	// the owner only, like the invite links
+	err = h.svc.AuthorizeInvite(ctx, int64(rid), uid.(int64))
+	switch err {
+	case nil:
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	default:
+		slog.Error("create guest link", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	expiresAt := time.Now().Add(guestLinkTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, GuestLinkClaims{
+		RetroID: int64(rid),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	tokenStr, err := token.SignedString(GuestLinkKey)
+	if err != nil {
+		slog.Error("guest link token generate error", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, Result{
+		Code: CodeOK,
+		Msg:  "create guest link success",
+		Data: inviteData{
+			Token:     tokenStr,
+			ExpiresAt: expiresAt,
+		},
+	})
+}
+
+// ParseGuestLink parses the token of a share link for the guests.
+func ParseGuestLink(tokenStr string) (GuestLinkClaims, error) {
+	var lc GuestLinkClaims
+	token, err := jwt.ParseWithClaims(tokenStr, &lc, func(t *jwt.Token) (interface{}, error) {
+		return GuestLinkKey, nil
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		return GuestLinkClaims{}, err
+	}
+
+	if !token.Valid || lc.RetroID == 0 {
+		return GuestLinkClaims{}, jwt.ErrTokenInvalidClaims
+	}
+
+	return lc, nil
+}
+
+// JoinAsGuest lets a visitor without an account join the retro of a guest
+// link with a display name
+func (h *RetroHandler) JoinAsGuest(ctx *gin.Context) {
+	type Req struct {
+		Token       string `json:"token"        binding:"required"`
+		DisplayName string `json:"display_name" binding:"required,max=32"`
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	lc, err := ParseGuestLink(req.Token)
+	if err != nil {
+		slog.Error("wrong guest link token", "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong guest link token",
+		})
+		return
+	}
+	rid := lc.RetroID
+
+	u, err := h.svc.JoinAsGuest(ctx, rid, req.DisplayName)
+	switch err {
+	case nil:
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case service.ErrTooManyGuests:
+		slog.Error("too many guests", "retro", rid, "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrDuplicatedUser:
+		slog.Error("guest name taken", "name", req.DisplayName, "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  "name already taken, try again",
+		})
+		return
+	default:
+		slog.Error("join as guest", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	tokenStr, err := getGuestToken(u, rid)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, Result{
+		Code: CodeOK,
+		Msg:  "join as guest success",
+		Data: guestData{
+			Token:    tokenStr,
+			Username: u.Username,
+			ID:       u.ID,
+			RetroID:  rid,
+		},
+	})
+}
+
+func getGuestToken(u repository.User, rid int64) (string, error) {
+	gc := GuestClaims{
+		UserClaims: UserClaims{
+			UID: u.ID,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(guestTTL)),
+			},
+		},
+		RetroID: rid,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, gc)
+	tokenStr, err := token.SignedString(GuestKey)
+	if err != nil {
+		slog.Error("guest token generate error", "err", err)
+		return "", err
+	}
+
+	return tokenStr, nil
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signToken(t *testing.T, claims jwt.Claims, key []byte) string {
+	t.Helper()
+	tokenStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return tokenStr
+}
+
+func TestParseGuestLinkOnlyAcceptsGuestLinks(t *testing.T) {
+	expiresAt := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	lc, err := ParseGuestLink(signToken(t, GuestLinkClaims{RegisteredClaims: expiresAt, RetroID: 1}, GuestLinkKey))
+	if err != nil || lc.RetroID != 1 {
+		t.Fatalf("got %+v, %v for a guest link, want retro 1", lc, err)
+	}
+
+	// an invite link of the members does not let anyone in as a guest
+	_, err = ParseGuestLink(signToken(t, InviteClaims{RegisteredClaims: expiresAt, RetroID: 1}, InviteKey))
+	if err == nil {
+		t.Fatal("accepted an invite link")
+	}
+	// nor the token of a guest, to create more guests
+	_, err = ParseGuestLink(signToken(t, GuestClaims{
+		UserClaims: UserClaims{RegisteredClaims: expiresAt, UID: 2},
+		RetroID:    1,
+	}, GuestKey))
+	if err == nil {
+		t.Fatal("accepted the token of a guest")
+	}
+
+	expired := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}
+	_, err = ParseGuestLink(signToken(t, GuestLinkClaims{RegisteredClaims: expired, RetroID: 1}, GuestLinkKey))
+	if err == nil {
+		t.Fatal("accepted an expired guest link")
+	}
+}
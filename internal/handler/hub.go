@@ -0,0 +1,459 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/chenmuyao/qooldown/internal/service"
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+var errBrokerClosed = errors.New("broker subscription closed")
+
+const (
+	eventQueueSize = 256
+
+	// sendQueueSize is the number of messages waiting for a client before it
+	// is considered too slow and dropped.
+	sendQueueSize = 64
+
+	// time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// time allowed to read the next pong message from the client
+	pongWait = 60 * time.Second
+	// send pings to the client with this period, must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+)
+
+// wsClient is a connection to a retro. Only its writePump writes to conn.
+type wsClient struct {
+	conn     *websocket.Conn
+	retroID  int64
+	uid      int64
+	username string
+
+	// owned by the readPump
+	lastRelayed map[relayKey]time.Time
+	limiter     *rate.Limiter
+
+	// sequence number and epoch of the last event received before
+	// reconnecting
+	lastSeq   int64
+	lastEpoch string
+	resume    bool
+
+	// owned by the hub
+	connectedAt time.Time
+	lastActive  time.Time
+
+	// outbound messages, closed by the hub when the client leaves
+	send chan WsJSONResponse
+
+	// heartbeat of the connection, pongWait and pingPeriod unless testing
+	pongWait   time.Duration
+	pingPeriod time.Duration
+
+	// closes the connection when the token expires
+	expire *time.Timer
+}
+
+func newWsClient(conn *websocket.Conn, rid int64, uid int64, username string) *wsClient {
+	now := time.Now()
+	return &wsClient{
+		conn:        conn,
+		retroID:     rid,
+		uid:         uid,
+		username:    username,
+		connectedAt: now,
+		lastActive:  now,
+		lastRelayed: make(map[relayKey]time.Time),
+		send:        make(chan WsJSONResponse, sendQueueSize),
+		pongWait:    pongWait,
+		pingPeriod:  pingPeriod,
+	}
+}
+
+// WsHub holds the connected clients grouped by retro ID, so that the
+// events of a retro are only sent to the people working on it.
+//
+// The rooms are owned by the Run goroutine, everything else talks to it
+// through the channels. The messages go through the broker first, to reach
+// the clients connected to the other instances as well. The instances share
+// their participants the same way.
+type WsHub struct {
+	broker Broker
+	// identifies the instance in the presence updates
+	instance string
+
+	rooms   map[int64]map[*wsClient]struct{}
+	streams map[int64]*retroStream
+	// participants of the other instances, by retro and instance
+	remote          map[int64]map[string]remotePresence
+	presenceVersion int64
+
+	register   chan *wsClient
+	unregister chan *wsClient
+	inbound    chan WsPayload
+	replies    chan clientReply
+	presence   chan presenceQuery
+}
+
+// clientReply is a message for a single client, like an error.
+type clientReply struct {
+	client   *wsClient
+	response WsJSONResponse
+}
+
+func NewWsHub(broker Broker) *WsHub {
+	return &WsHub{
+		broker:     broker,
+		instance:   randomID(),
+		rooms:      make(map[int64]map[*wsClient]struct{}),
+		streams:    make(map[int64]*retroStream),
+		remote:     make(map[int64]map[string]remotePresence),
+		register:   make(chan *wsClient),
+		unregister: make(chan *wsClient),
+		inbound:    make(chan WsPayload),
+		replies:    make(chan clientReply),
+		presence:   make(chan presenceQuery),
+	}
+}
+
+// Publish sends the event to the clients of its retro.
+func (hub *WsHub) Publish(ctx context.Context, event service.Event) {
+	err := hub.broker.Publish(ctx, BrokerMessage{
+		RetroID: event.RetroID,
+		Event:   &event,
+	})
+	if err != nil {
+		slog.Error("ws event dropped", "type", event.Type, "retro", event.RetroID, "err", err)
+	}
+}
+
+// relay sends the message of a client to the others in the retro.
+func (hub *WsHub) relay(ctx context.Context, c *wsClient, p WsPayload) {
+	msg := BrokerMessage{
+		RetroID:  c.retroID,
+		SenderID: c.uid,
+		Relay: &WsJSONResponse{
+			Action:     p.Action,
+			RetroID:    c.retroID,
+			QuestionID: p.QuestionID,
+			UserID:     c.uid,
+			Username:   c.username,
+			Cursor:     p.Cursor,
+		},
+	}
+
+	err := hub.broker.Publish(ctx, msg)
+	if err != nil {
+		slog.Error("ws relay dropped", "action", p.Action, "retro", c.retroID, "err", err)
+	}
+}
+
+// Run dispatches the messages to the rooms until the context is done or the
+// broker fails. It must be started once before accepting connections.
+func (hub *WsHub) Run(ctx context.Context) error {
+	msgs, err := hub.broker.Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(presenceRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			hub.refreshPresence(now)
+		case c := <-hub.register:
+			hub.add(c)
+		case c := <-hub.unregister:
+			hub.remove(c)
+		case q := <-hub.presence:
+			q.reply <- hub.participants(q.retroID)
+		case p := <-hub.inbound:
+			if !hub.isMember(p.Client) {
+				// already left
+				continue
+			}
+			p.Client.lastActive = time.Now()
+		case r := <-hub.replies:
+			hub.sendTo(r.client, r.response)
+		case msg, ok := <-msgs:
+			if !ok {
+				return errBrokerClosed
+			}
+			hub.dispatch(msg)
+		}
+	}
+}
+
+// dispatch sends the message from the broker to the clients of its retro.
+func (hub *WsHub) dispatch(msg BrokerMessage) {
+	if msg.Presence != nil {
+		hub.updatePresence(msg.RetroID, *msg.Presence)
+		return
+	}
+
+	if msg.Relay != nil {
+		slog.Info("ws server received", "action", msg.Relay.Action, "retro", msg.RetroID)
+		hub.broadcastExcept(msg.RetroID, msg.SenderID, func(*wsClient) WsJSONResponse {
+			return *msg.Relay
+		})
+		return
+	}
+
+	if msg.Event == nil {
+		return
+	}
+
+	se := sequencedEvent{
+		seq:   msg.Seq,
+		epoch: msg.Epoch,
+		event: *msg.Event,
+	}
+	hub.stream(msg.RetroID).append(se.seq, se.epoch, se.event)
+	hub.broadcast(msg.RetroID, func(c *wsClient) WsJSONResponse {
+		return eventResponse(c, se)
+	})
+	if se.event.Type == service.EventRetroDeleted {
+		delete(hub.streams, msg.RetroID)
+	}
+}
+
+// add puts the client in its room and tells the others if it is the first
+// connection of the user.
+func (hub *WsHub) add(c *wsClient) {
+	present := hub.isPresent(c.retroID, c.uid)
+	before := hub.present(c.retroID)
+	hub.join(c)
+
+	hub.sendTo(c, WsJSONResponse{
+		Message: "Connected to server",
+		RetroID: c.retroID,
+		Seq:     hub.stream(c.retroID).seq,
+		Epoch:   hub.stream(c.retroID).epoch,
+	})
+	if c.resume {
+		hub.replay(c)
+	}
+
+	if present {
+		return
+	}
+	hub.sharePresence(c.retroID)
+	hub.announce(c.retroID, before)
+}
+
+// remove drops the client from its room and tells the others if it was the
+// last connection of the user.
+func (hub *WsHub) remove(c *wsClient) {
+	if !hub.isMember(c) {
+		return
+	}
+	before := hub.present(c.retroID)
+	hub.leave(c)
+	if hub.isPresent(c.retroID, c.uid) {
+		return
+	}
+	hub.sharePresence(c.retroID)
+	hub.announce(c.retroID, before)
+}
+
+func (hub *WsHub) join(c *wsClient) {
+	room, ok := hub.rooms[c.retroID]
+	if !ok {
+		room = make(map[*wsClient]struct{})
+		hub.rooms[c.retroID] = room
+	}
+	room[c] = struct{}{}
+}
+
+func (hub *WsHub) leave(c *wsClient) {
+	room, ok := hub.rooms[c.retroID]
+	if !ok {
+		return
+	}
+	if _, ok := room[c]; !ok {
+		// already dropped
+		return
+	}
+	delete(room, c)
+	close(c.send)
+	if len(room) == 0 {
+		// nobody left in the retro
+		delete(hub.rooms, c.retroID)
+	}
+}
+
+func (hub *WsHub) isMember(c *wsClient) bool {
+	_, ok := hub.rooms[c.retroID][c]
+	return ok
+}
+
+// sendTo queues the response for the client, or drops the client if its queue
+// is full.
+func (hub *WsHub) sendTo(c *wsClient, response WsJSONResponse) {
+	if !hub.isMember(c) {
+		// send is closed
+		return
+	}
+	select {
+	case c.send <- response:
+	default:
+		slog.Error("ws client too slow", "retro", c.retroID, "uid", c.uid)
+		hub.remove(c)
+	}
+}
+
+// broadcast queues the response built for each client of the retro. A client
+// whose queue is full is dropped instead of blocking the others.
+func (hub *WsHub) broadcast(rid int64, build func(c *wsClient) WsJSONResponse) {
+	hub.broadcastExcept(rid, 0, build)
+}
+
+// broadcastExcept is broadcast, skipping the clients of the user.
+func (hub *WsHub) broadcastExcept(
+	rid int64,
+	uid int64,
+	build func(c *wsClient) WsJSONResponse,
+) {
+	var slow []*wsClient
+	for c := range hub.rooms[rid] {
+		if uid != 0 && c.uid == uid {
+			continue
+		}
+		select {
+		case c.send <- build(c):
+		default:
+			slog.Error("ws client too slow", "retro", rid, "uid", c.uid)
+			slow = append(slow, c)
+		}
+	}
+	for _, c := range slow {
+		hub.remove(c)
+	}
+}
+
+// readPump forwards the messages of the client to the hub until the
+// connection fails.
+func (c *wsClient) readPump(hub *WsHub) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("ws panic", "err", r)
+		}
+	}()
+	defer func() {
+		if c.expire != nil {
+			c.expire.Stop()
+		}
+		hub.unregister <- c
+		_ = c.conn.Close()
+	}()
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	})
+
+	violations := 0
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(
+				err,
+				websocket.CloseGoingAway,
+				websocket.CloseNormalClosure,
+				websocket.CloseMessageTooBig,
+			) {
+				slog.Error("ws read error", "err", err)
+			}
+			break
+		}
+
+		if !c.limiter.Allow() {
+			violations++
+			if violations >= maxRateViolations {
+				slog.Error("ws rate limit", "retro", c.retroID, "uid", c.uid)
+				c.closeWith(websocket.ClosePolicyViolation, errWsRateLimit.Error())
+				break
+			}
+			hub.replyError(c, errWsRateLimit)
+			continue
+		}
+		violations = 0
+
+		var payload WsPayload
+		err = json.Unmarshal(data, &payload)
+		if err != nil {
+			hub.replyError(c, errWsMalformed)
+			continue
+		}
+		err = payload.validate()
+		if err != nil {
+			hub.replyError(c, err)
+			continue
+		}
+
+		payload.Client = c
+		hub.inbound <- payload
+
+		if payload.Action == ActionActive || c.throttled(payload, time.Now()) {
+			continue
+		}
+		hub.relay(context.Background(), c, payload)
+	}
+}
+
+// replyError tells the client its message was dropped.
+func (hub *WsHub) replyError(c *wsClient, err error) {
+	hub.replies <- clientReply{
+		client: c,
+		response: WsJSONResponse{
+			Action:  ActionError,
+			Message: err.Error(),
+			RetroID: c.retroID,
+		},
+	}
+}
+
+// writePump sends the queued messages and the heartbeats to the client. It
+// is the only writer of the connection.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(c.pingPeriod)
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+	}()
+
+	for {
+		select {
+		case response, ok := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// the hub dropped the client
+				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			err := c.conn.WriteJSON(response)
+			if err != nil {
+				slog.Error("ws error on action", "action", response.Action, "err", err)
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
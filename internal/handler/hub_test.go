@@ -0,0 +1,402 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chenmuyao/qooldown/internal/service"
+	"github.com/gorilla/websocket"
+)
+
+// testHeartbeat shortens the heartbeat of the connections, zero for the
+// defaults.
+type testHeartbeat struct {
+	pongWait   time.Duration
+	pingPeriod time.Duration
+}
+
+// newTestWsServer accepts the connections like WsEndPoint, without the
+// authentication: /?retro_id=1&uid=2&last_seq=42&epoch=XXXX
+func newTestWsServer(t *testing.T, hub *WsHub, hb testHeartbeat) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid, _ := strconv.ParseInt(r.URL.Query().Get("retro_id"), 10, 64)
+		uid, _ := strconv.ParseInt(r.URL.Query().Get("uid"), 10, 64)
+
+		conn, err := upgradeConnection.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := newWsClient(conn, rid, uid, fmt.Sprintf("user%d", uid))
+		c.limiter = WsLimits{Rate: 10000, Burst: 10000}.newLimiter()
+		lastSeqStr, resume := r.URL.Query()["last_seq"]
+		if resume {
+			c.lastSeq, _ = strconv.ParseInt(lastSeqStr[0], 10, 64)
+			c.lastEpoch, c.resume = r.URL.Query().Get("epoch"), true
+		}
+		if hb.pongWait != 0 {
+			c.pongWait, c.pingPeriod = hb.pongWait, hb.pingPeriod
+		}
+
+		hub.register <- c
+		go c.writePump()
+		go c.readPump(hub)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dial connects the user to the retro and reads the greeting.
+func dial(t *testing.T, srv *httptest.Server, rid int64, uid int64) *websocket.Conn {
+	t.Helper()
+	return dialQuery(t, srv, rid, uid, "")
+}
+
+// dialQuery connects with more parameters, "&last_seq=42" for example.
+func dialQuery(t *testing.T, srv *httptest.Server, rid int64, uid int64, query string) *websocket.Conn {
+	t.Helper()
+	url := fmt.Sprintf("ws%s/?retro_id=%d&uid=%d%s", strings.TrimPrefix(srv.URL, "http"), rid, uid, query)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	greeting := readResponse(t, conn)
+	if greeting.Message != "Connected to server" || greeting.RetroID != rid {
+		t.Fatalf("got greeting %+v", greeting)
+	}
+	return conn
+}
+
+func readResponse(t *testing.T, conn *websocket.Conn) WsJSONResponse {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(testTimeout))
+	var r WsJSONResponse
+	err := conn.ReadJSON(&r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return r
+}
+
+// readAction returns the next response with the action, skipping the others.
+func readAction(t *testing.T, conn *websocket.Conn, action string) WsJSONResponse {
+	t.Helper()
+	for {
+		r := readResponse(t, conn)
+		if r.Action == action {
+			return r
+		}
+	}
+}
+
+func TestHubRegisterUnregister(t *testing.T) {
+	hub := runHub(t, NewMemoryBroker())
+	srv := newTestWsServer(t, hub, testHeartbeat{})
+
+	alice := dial(t, srv, 1, 1)
+	waitParticipants(t, hub, 1, 1)
+
+	bob := dial(t, srv, 1, 2)
+	// a second tab of bob counts once
+	dial(t, srv, 1, 2)
+	// alice is told about herself first, then bob once
+	for _, uid := range []int64{1, 2} {
+		joined := readAction(t, alice, ActionParticipantJoined)
+		if joined.Participant.UID != uid {
+			t.Fatalf("got %+v joined, want %d", joined.Participant, uid)
+		}
+	}
+	waitParticipants(t, hub, 1, 1, 2)
+
+	_ = bob.Close()
+	waitParticipants(t, hub, 1, 1, 2)
+
+	_ = alice.Close()
+	waitParticipants(t, hub, 1, 2)
+}
+
+func TestHubRoutesToRetroRoom(t *testing.T) {
+	hub := runHub(t, NewMemoryBroker())
+	srv := newTestWsServer(t, hub, testHeartbeat{})
+
+	inRetro1 := dial(t, srv, 1, 1)
+	inRetro2 := dial(t, srv, 2, 2)
+
+	ctx := context.Background()
+	hub.Publish(ctx, service.Event{Type: service.EventPostitCreated, RetroID: 1})
+	hub.Publish(ctx, service.Event{Type: service.EventPostitDeleted, RetroID: 2})
+
+	r := readAction(t, inRetro1, string(service.EventPostitCreated))
+	if r.RetroID != 1 || r.Seq != 1 {
+		t.Fatalf("got %+v in retro 1", r)
+	}
+	// the events are sent in order, the one of retro 1 would come first
+	for {
+		r = readResponse(t, inRetro2)
+		if r.Action == ActionParticipantJoined {
+			continue
+		}
+		if r.Action != string(service.EventPostitDeleted) || r.RetroID != 2 {
+			t.Fatalf("got %+v in retro 2", r)
+		}
+		break
+	}
+
+	// the ephemeral messages are not sent back to their sender
+	err := inRetro1.WriteJSON(WsPayload{Action: ActionTypingStart, QuestionID: 3})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	other := dial(t, srv, 1, 3)
+	err = inRetro1.WriteJSON(WsPayload{Action: ActionTypingStop, QuestionID: 3})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	r = readAction(t, other, ActionTypingStop)
+	if r.UserID != 1 || r.QuestionID != 3 {
+		t.Fatalf("got %+v relayed", r)
+	}
+}
+
+// readEvents reads the next n events, skipping the other messages.
+func readEvents(t *testing.T, conn *websocket.Conn, n int) []WsJSONResponse {
+	t.Helper()
+	var events []WsJSONResponse
+	for len(events) < n {
+		r := readResponse(t, conn)
+		switch r.Action {
+		case ActionParticipantJoined, ActionParticipantLeft:
+			continue
+		case ActionResyncRequired:
+			t.Fatalf("got %+v, want the events", r)
+		}
+		events = append(events, r)
+	}
+	return events
+}
+
+func TestHubReplaysMissedEvents(t *testing.T) {
+	hub := runHub(t, NewMemoryBroker())
+	srv := newTestWsServer(t, hub, testHeartbeat{})
+	ctx := context.Background()
+	publish := func(n int) {
+		for range n {
+			hub.Publish(ctx, service.Event{Type: service.EventPostitCreated, RetroID: 1})
+		}
+	}
+
+	// sees the events dispatched by the hub
+	other := dial(t, srv, 1, 2)
+	conn := dial(t, srv, 1, 1)
+	publish(2)
+	live := readEvents(t, conn, 2)
+	epoch := live[1].Epoch
+	if epoch == "" || live[1].Seq != 2 {
+		t.Fatalf("got %+v live, want seq 2 with an epoch", live[1])
+	}
+	_ = conn.Close()
+	waitParticipants(t, hub, 1, 2)
+
+	// missed while disconnected
+	publish(3)
+	readEvents(t, other, 5)
+
+	conn = dialQuery(t, srv, 1, 1, fmt.Sprintf("&last_seq=%d&epoch=%s", live[1].Seq, epoch))
+	replayed := readEvents(t, conn, 3)
+	for i, r := range replayed {
+		if r.Action != string(service.EventPostitCreated) || r.Seq != int64(i+3) || r.Epoch != epoch {
+			t.Fatalf("replayed %d: got %+v, want seq %d of epoch %q", i, r, i+3, epoch)
+		}
+	}
+	_ = conn.Close()
+	waitParticipants(t, hub, 1, 2)
+
+	// resumes again from a replayed event, nothing to replay
+	last := replayed[2]
+	conn = dialQuery(t, srv, 1, 1, fmt.Sprintf("&last_seq=%d&epoch=%s", last.Seq, last.Epoch))
+	publish(1)
+	if r := readEvents(t, conn, 1)[0]; r.Seq != 6 || r.Epoch != epoch {
+		t.Fatalf("got %+v after resuming, want seq 6", r)
+	}
+
+	// the numbering of another epoch cannot be resumed
+	readEvents(t, other, 1)
+	conn = dialQuery(t, srv, 1, 3, "&last_seq=6&epoch=other")
+	r := readAction(t, conn, ActionResyncRequired)
+	if r.Seq != 6 || r.Epoch != epoch {
+		t.Fatalf("got %+v, want a resync to seq 6 of epoch %q", r, epoch)
+	}
+}
+
+func TestHubDropsSlowClient(t *testing.T) {
+	hub := runHub(t, NewMemoryBroker())
+	srv := newTestWsServer(t, hub, testHeartbeat{})
+
+	// never reads its queue
+	slow := newWsClient(nil, 1, 1, "slow")
+	hub.register <- slow
+	fast := dial(t, srv, 1, 2)
+
+	// the others still get everything, at their pace
+	ctx := context.Background()
+	dropped := false
+	for i := range sendQueueSize + 1 {
+		hub.Publish(ctx, service.Event{Type: service.EventPostitCreated, RetroID: 1})
+		for {
+			r := readResponse(t, fast)
+			if r.Action == ActionParticipantLeft && r.Participant.UID == 1 {
+				dropped = true
+				continue
+			}
+			if r.Action != string(service.EventPostitCreated) {
+				continue
+			}
+			if r.Seq != int64(i+1) {
+				t.Fatalf("got seq %d, want %d", r.Seq, i+1)
+			}
+			break
+		}
+	}
+	if !dropped {
+		t.Fatal("the others were not told the slow client left")
+	}
+	waitParticipants(t, hub, 1, 2)
+
+	// the queue is closed once the messages already in it are read
+	n := 0
+	for range slow.send {
+		n++
+	}
+	if n != sendQueueSize {
+		t.Fatalf("got %d messages queued, want %d", n, sendQueueSize)
+	}
+}
+
+func TestHubHeartbeat(t *testing.T) {
+	hb := testHeartbeat{
+		pongWait:   300 * time.Millisecond,
+		pingPeriod: 100 * time.Millisecond,
+	}
+	hub := runHub(t, NewMemoryBroker())
+	srv := newTestWsServer(t, hub, hb)
+
+	// the default ping handler answers with a pong while reading
+	alive := dial(t, srv, 1, 1)
+	dead := dial(t, srv, 1, 2)
+	dead.SetPingHandler(func(string) error {
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, conn := range []*websocket.Conn{alive, dead} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = conn.SetReadDeadline(time.Now().Add(4 * hb.pongWait))
+			for {
+				_, _, err := conn.ReadMessage()
+				if err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var netErr net.Error
+	if !errors.As(errs[0], &netErr) || !netErr.Timeout() {
+		t.Fatalf("got %v for the client answering the pings, want a timeout of the test", errs[0])
+	}
+	if errors.As(errs[1], &netErr) && netErr.Timeout() {
+		t.Fatal("the server kept the client without pongs")
+	}
+	waitParticipants(t, hub, 1, 1)
+}
+
+func TestHubParticipantsDuringTraffic(t *testing.T) {
+	hub := runHub(t, NewMemoryBroker())
+	srv := newTestWsServer(t, hub, testHeartbeat{})
+
+	const clients = 10
+	conns := make([]*websocket.Conn, clients)
+	uids := make([]int64, clients)
+	for i := range conns {
+		uids[i] = int64(i + 1)
+		conns[i] = dial(t, srv, 1, uids[i])
+	}
+	waitParticipants(t, hub, 1, uids...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		// drain
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = conn.SetReadDeadline(time.Time{})
+			for {
+				_, _, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	var traffic sync.WaitGroup
+	for i, conn := range conns {
+		traffic.Add(1)
+		go func() {
+			defer traffic.Done()
+			for ctx.Err() == nil {
+				p := WsPayload{Action: ActionActive}
+				if i%2 == 0 {
+					p = WsPayload{Action: ActionCursorMove, Cursor: &Cursor{X: 0.5, Y: 0.5}}
+				}
+				if conn.WriteJSON(p) != nil {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	traffic.Add(1)
+	go func() {
+		defer traffic.Done()
+		for ctx.Err() == nil {
+			hub.Publish(ctx, service.Event{Type: service.EventPostitUpdated, RetroID: 1})
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	for range 100 {
+		got := hub.Participants(1)
+		if len(got) != clients {
+			t.Errorf("got %d participants during the traffic, want %d", len(got), clients)
+			break
+		}
+	}
+	cancel()
+	traffic.Wait()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	wg.Wait()
+	waitParticipants(t, hub, 1)
+}
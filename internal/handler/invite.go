@@ -0,0 +1,347 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chenmuyao/qooldown/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// InviteKey signs the invite links, so that they cannot be used as login
+// tokens.
+var InviteKey = []byte("Zr7kT2mWq9LxV4bNc8HsJ3fYp6DgE1uA")
+
+const inviteTTL = 7 * 24 * time.Hour
+
+// InviteClaims is the content of an invite link to a retro.
+type InviteClaims struct {
+	jwt.RegisteredClaims
+	RetroID int64
+}
+
+type inviteData struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// InviteUser makes a user a member of the retro by username
+func (h *RetroHandler) InviteUser(ctx *gin.Context) {
+	type Req struct {
+		Username string `json:"username" binding:"required"`
+		// participant if not set
+		Role string `json:"role"`
+	}
+
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	u, err := h.svc.InviteUser(ctx, int64(rid), uid.(int64), req.Username, req.Role)
+	switch err {
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrInvalidRole:
+		slog.Error("invalid role", "role", req.Role, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrUserNotFound:
+		slog.Error("user not found", "username", req.Username, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "invite user success",
+			Data: u,
+		})
+		return
+	default:
+		slog.Error("invite user", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
+// CreateInviteLink returns a token that lets anyone logged in join the retro
+func (h *RetroHandler) CreateInviteLink(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err = h.svc.AuthorizeInvite(ctx, int64(rid), uid.(int64))
+	switch err {
+	case nil:
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	default:
+		slog.Error("create invite link", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	expiresAt := time.Now().Add(inviteTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, InviteClaims{
+		RetroID: int64(rid),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	tokenStr, err := token.SignedString(InviteKey)
+	if err != nil {
+		slog.Error("invite token generate error", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, Result{
+		Code: CodeOK,
+		Msg:  "create invite link success",
+		Data: inviteData{
+			Token:     tokenStr,
+			ExpiresAt: expiresAt,
+		},
+	})
+}
+
+// JoinRetro makes the user a member of the retro of the invite link
+func (h *RetroHandler) JoinRetro(ctx *gin.Context) {
+	type Req struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	var ic InviteClaims
+	token, err := jwt.ParseWithClaims(req.Token, &ic, func(t *jwt.Token) (interface{}, error) {
+		return InviteKey, nil
+	}, jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		slog.Error("wrong invite token", "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong invite token",
+		})
+		return
+	}
+
+	err = h.svc.JoinRetro(ctx, ic.RetroID, uid.(int64))
+	switch err {
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", ic.RetroID, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "join retro success",
+			Data: ic.RetroID,
+		})
+		return
+	default:
+		slog.Error("join retro", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
+// SetMemberRole changes the role of a member of the retro
+func (h *RetroHandler) SetMemberRole(ctx *gin.Context) {
+	type Req struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	memberStr := ctx.Param("uid")
+
+	member, err := strconv.Atoi(memberStr)
+	if err != nil {
+		slog.Error("wrong user id", "id", memberStr, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong user id",
+		})
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err = h.svc.SetMemberRole(ctx, int64(rid), uid.(int64), int64(member), req.Role)
+	h.memberChanged(ctx, err, int64(rid), "set member role success")
+}
+
+// HandOverFacilitation makes another member the facilitator of the retro
+func (h *RetroHandler) HandOverFacilitation(ctx *gin.Context) {
+	type Req struct {
+		UserID int64 `json:"user_id" binding:"required"`
+	}
+
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err = h.svc.HandOverFacilitation(ctx, int64(rid), uid.(int64), req.UserID)
+	h.memberChanged(ctx, err, int64(rid), "hand over facilitation success")
+}
+
+// memberChanged answers the change of a member.
+func (h *RetroHandler) memberChanged(ctx *gin.Context, err error, rid int64, msg string) {
+	switch err {
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+	case service.ErrInvalidRole, service.ErrNotMember:
+		slog.Error("wrong member", "retro", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  msg,
+		})
+	default:
+		slog.Error("change member", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+	}
+}
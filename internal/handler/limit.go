@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+const (
+	ActionError = "error"
+
+	// the connection is closed after this many messages over the rate limit
+	// in a row
+	maxRateViolations = 10
+)
+
+var (
+	errWsMalformed     = errors.New("malformed message")
+	errWsUnknownAction = errors.New("unknown action")
+	errWsNoQuestion    = errors.New("question_id is required")
+	errWsBadCursor     = errors.New("cursor is required, with x and y between 0 and 1")
+	errWsRateLimit     = errors.New("rate limit exceeded")
+)
+
+// WsLimits protects the rooms from the clients sending too much.
+type WsLimits struct {
+	// maximum size of a message in bytes
+	MaxMessageSize int64
+	// messages per second allowed for a connection, and the burst above it
+	Rate  float64
+	Burst int
+}
+
+func (l WsLimits) newLimiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(l.Rate), l.Burst)
+}
+
+// validate checks the payload against the schema of its action.
+func (p WsPayload) validate() error {
+	switch p.Action {
+	case ActionTypingStart, ActionTypingStop:
+		if p.QuestionID <= 0 {
+			return errWsNoQuestion
+		}
+	case ActionCursorMove:
+		if p.Cursor == nil ||
+			p.Cursor.X < 0 || p.Cursor.X > 1 ||
+			p.Cursor.Y < 0 || p.Cursor.Y > 1 {
+			return errWsBadCursor
+		}
+	case ActionActive:
+	default:
+		return errWsUnknownAction
+	}
+	return nil
+}
+
+// closeWith closes the connection with the code. It can be called
+// concurrently with the writePump.
+func (c *wsClient) closeWith(code int, text string) {
+	msg := websocket.FormatCloseMessage(code, text)
+	err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	if err != nil {
+		slog.Error("ws write close error", "err", err)
+	}
+	// unblocks the readPump
+	_ = c.conn.Close()
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const (
+	ActionParticipantJoined = "participant_joined"
+	ActionParticipantLeft   = "participant_left"
+
+	// a participant without any message for this long is idle
+	idleAfter = 5 * time.Minute
+
+	// every instance shares its participants this often, and forgets the
+	// ones of an instance it has not heard of for presenceTTL
+	presenceRefresh = 30 * time.Second
+	presenceTTL     = 3 * presenceRefresh
+)
+
+// Participant is a user connected to a retro. The connections of a user
+// (several tabs for example) are merged.
+type Participant struct {
+	UID            int64     `json:"id"`
+	Username       string    `json:"username"`
+	ConnectedSince time.Time `json:"connected_since"`
+	LastActive     time.Time `json:"last_active"`
+	Idle           bool      `json:"idle"`
+}
+
+// PresenceUpdate is the list of the participants of a retro connected to an
+// instance, shared with the other instances through the broker.
+type PresenceUpdate struct {
+	Instance string `json:"instance"`
+	// the updates of an instance may be received out of order
+	Version      int64         `json:"version"`
+	Participants []Participant `json:"participants"`
+}
+
+// remotePresence is the last update of another instance.
+type remotePresence struct {
+	version      int64
+	participants []Participant
+	seen         time.Time
+}
+
+type presenceQuery struct {
+	retroID int64
+	reply   chan []Participant
+}
+
+// Participants returns the users currently connected to the retro, on every
+// instance.
+func (hub *WsHub) Participants(rid int64) []Participant {
+	q := presenceQuery{
+		retroID: rid,
+		reply:   make(chan []Participant, 1),
+	}
+	hub.presence <- q
+	return <-q.reply
+}
+
+// participants merges the clients of the retro by user, with the ones of the
+// other instances. Must be called from the Run goroutine.
+func (hub *WsHub) participants(rid int64) []Participant {
+	byUser := hub.present(rid)
+
+	res := make([]Participant, 0, len(byUser))
+	for _, p := range byUser {
+		p.Idle = time.Since(p.LastActive) > idleAfter
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ConnectedSince.Before(res[j].ConnectedSince)
+	})
+	return res
+}
+
+// present returns the participants of the retro by user ID, on every
+// instance. Must be called from the Run goroutine.
+func (hub *WsHub) present(rid int64) map[int64]Participant {
+	byUser := make(map[int64]Participant)
+	for _, p := range hub.localParticipants(rid) {
+		byUser[p.UID] = p
+	}
+	for _, r := range hub.remote[rid] {
+		for _, p := range r.participants {
+			byUser[p.UID] = mergeParticipant(byUser[p.UID], p)
+		}
+	}
+	return byUser
+}
+
+// localParticipants merges the clients of the retro connected to this
+// instance by user. Must be called from the Run goroutine.
+func (hub *WsHub) localParticipants(rid int64) []Participant {
+	byUser := make(map[int64]Participant)
+	for c := range hub.rooms[rid] {
+		byUser[c.uid] = mergeParticipant(byUser[c.uid], Participant{
+			UID:            c.uid,
+			Username:       c.username,
+			ConnectedSince: c.connectedAt,
+			LastActive:     c.lastActive,
+		})
+	}
+
+	res := make([]Participant, 0, len(byUser))
+	for _, p := range byUser {
+		res = append(res, p)
+	}
+	return res
+}
+
+// mergeParticipant merges two connections of the same user, the first one
+// may be empty.
+func mergeParticipant(p Participant, other Participant) Participant {
+	if p.UID == 0 {
+		return other
+	}
+	if other.ConnectedSince.Before(p.ConnectedSince) {
+		p.ConnectedSince = other.ConnectedSince
+	}
+	if other.LastActive.After(p.LastActive) {
+		p.LastActive = other.LastActive
+	}
+	return p
+}
+
+// announce tells the local clients of the retro who joined or left since
+// before. Must be called from the Run goroutine.
+func (hub *WsHub) announce(rid int64, before map[int64]Participant) {
+	after := hub.present(rid)
+	for uid, p := range after {
+		if _, ok := before[uid]; ok {
+			continue
+		}
+		hub.broadcast(rid, func(*wsClient) WsJSONResponse {
+			return WsJSONResponse{
+				Action:      ActionParticipantJoined,
+				RetroID:     rid,
+				Participant: &p,
+			}
+		})
+	}
+	for uid, p := range before {
+		if _, ok := after[uid]; ok {
+			continue
+		}
+		hub.broadcast(rid, func(*wsClient) WsJSONResponse {
+			return WsJSONResponse{
+				Action:  ActionParticipantLeft,
+				RetroID: rid,
+				Participant: &Participant{
+					UID:      p.UID,
+					Username: p.Username,
+				},
+			}
+		})
+	}
+}
+
+// sharePresence sends the local participants of the retro to the other
+// instances. Must be called from the Run goroutine.
+func (hub *WsHub) sharePresence(rid int64) {
+	hub.presenceVersion++
+	msg := BrokerMessage{
+		RetroID: rid,
+		Presence: &PresenceUpdate{
+			Instance:     hub.instance,
+			Version:      hub.presenceVersion,
+			Participants: hub.localParticipants(rid),
+		},
+	}
+
+	// NOTE: the Run goroutine also reads the messages of the broker
+	go func() {
+		err := hub.broker.Publish(context.Background(), msg)
+		if err != nil {
+			slog.Error("ws presence dropped", "retro", rid, "err", err)
+		}
+	}()
+}
+
+// updatePresence keeps the participants of another instance. Must be called
+// from the Run goroutine.
+func (hub *WsHub) updatePresence(rid int64, u PresenceUpdate) {
+	if u.Instance == hub.instance {
+		return
+	}
+	r, known := hub.remote[rid][u.Instance]
+	if known && r.version >= u.Version {
+		// outdated
+		return
+	}
+	if !known && len(hub.rooms[rid]) > 0 {
+		// a new instance does not know the participants of this one yet
+		hub.sharePresence(rid)
+	}
+
+	before := hub.present(rid)
+	instances, ok := hub.remote[rid]
+	if !ok {
+		instances = make(map[string]remotePresence)
+		hub.remote[rid] = instances
+	}
+	// NOTE: kept even if nobody is left, to drop the older updates still on
+	// their way
+	instances[u.Instance] = remotePresence{
+		version:      u.Version,
+		participants: u.Participants,
+		seen:         time.Now(),
+	}
+	hub.announce(rid, before)
+}
+
+// refreshPresence shares the local participants again, and forgets the
+// instances which stopped sending theirs. Must be called from the Run
+// goroutine.
+func (hub *WsHub) refreshPresence(now time.Time) {
+	for rid := range hub.rooms {
+		hub.sharePresence(rid)
+	}
+
+	for rid, instances := range hub.remote {
+		before := hub.present(rid)
+		for instance, r := range instances {
+			if now.Sub(r.seen) > presenceTTL {
+				slog.Info("ws instance gone", "instance", instance, "retro", rid)
+				delete(instances, instance)
+			}
+		}
+		if len(instances) == 0 {
+			delete(hub.remote, rid)
+		}
+		hub.announce(rid, before)
+	}
+}
+
+// isPresent tells if the user has a connection to the retro on this instance.
+// Must be called from the Run goroutine.
+func (hub *WsHub) isPresent(rid int64, uid int64) bool {
+	for c := range hub.rooms[rid] {
+		if c.uid == uid {
+			return true
+		}
+	}
+	return false
+}
@@ -34,24 +34,54 @@ func (h *RetroHandler) RegisterRoutes(server *gin.Engine) {
 	retros.GET("/:id", h.GetRetroByID)
 	retros.DELETE("/:id", h.DeleteRetroByID)
 	retros.GET("/:id/top", h.GetTopVotePostits)
+	retros.POST("/:id/phase", h.ChangePhase)
+	retros.POST("/:id/reveal", h.RevealRetro)
+	retros.POST("/:id/timer", h.ControlTimer)
+	retros.GET("/:id/my-votes", h.GetMyVotes)
+	retros.POST("/:id/members", h.InviteUser)
+	retros.POST("/:id/members/:uid/role", h.SetMemberRole)
+	retros.POST("/:id/facilitator", h.HandOverFacilitation)
+	retros.POST("/:id/invite-link", h.CreateInviteLink)
+	retros.POST("/:id/guest-link", h.CreateGuestLink)
+	retros.POST("/join", h.JoinRetro)
+
+	guests := server.Group("/guests")
+	guests.POST("/join", h.JoinAsGuest)
 
 	postits := server.Group("/postits")
 	postits.POST("/", h.CreatePostit)
 	postits.POST("/:id", h.UpdatePostitByID)
 	postits.DELETE("/:id", h.DeletePostitByID)
 	postits.POST("/:id/vote", h.VotePostitByID)
+	postits.DELETE("/:id/vote", h.UnvotePostitByID)
+	postits.POST("/:id/merge", h.MergePostits)
+	postits.POST("/:id/position", h.MovePostit)
+	postits.GET("/:id/comments", h.GetComments)
+	postits.POST("/:id/comments", h.CreateComment)
+	postits.POST("/:id/comments/:cid", h.UpdateComment)
+	postits.DELETE("/:id/comments/:cid", h.DeleteCommentByID)
+
+	groups := server.Group("/groups")
+	groups.POST("/", h.CreateGroup)
+	groups.POST("/:id", h.RenameGroup)
+	groups.DELETE("/:id", h.DissolveGroup)
+	groups.POST("/:id/postits", h.AddPostitToGroup)
+	groups.DELETE("/:id/postits/:pid", h.RemovePostitFromGroup)
+	groups.POST("/:id/vote", h.VoteGroupByID)
+	groups.DELETE("/:id/vote", h.UnvoteGroupByID)
 }
 
 // {{{ Templates
 
 func (h *RetroHandler) CreateTemplate(ctx *gin.Context) {
 	type Req struct {
-		Name      string   `json:"name"      binding:"required"`
-		Questions []string `json:"questions" binding:"required"`
+		Name                string   `json:"name"                   binding:"required"`
+		Questions           []string `json:"questions"              binding:"required"`
+		VotesPerUser        int      `json:"votes_per_user"         binding:"min=0"`
+		AuthorizeSelfVote   *bool    `json:"authorize_self_vote"`
+		MaxVotesPerPostit   int      `json:"max_votes_per_postit"   binding:"min=0"`
+		HideVotesDuringVote bool     `json:"hide_votes_during_vote"`
 	}
-	// TODO:
-	// VotesPerUser      int      `json:"votes_per_user"`
-	// AuthorizeSelfVote bool     `json:"authorize_self_vote"`
 
 	var req Req
 
@@ -80,6 +110,12 @@ func (h *RetroHandler) CreateTemplate(ctx *gin.Context) {
 		Name:      req.Name,
 		UserID:    uid.(int64),
 		Questions: questions,
+		VotingRules: repository.VotingRules{
+			VotesPerUser:        req.VotesPerUser,
+			AuthorizeSelfVote:   req.AuthorizeSelfVote,
+			MaxVotesPerPostit:   req.MaxVotesPerPostit,
+			HideVotesDuringVote: req.HideVotesDuringVote,
+		},
 	}
 
 	t, err := h.svc.CreateTemplate(ctx, template)
@@ -214,6 +250,7 @@ func (h *RetroHandler) CreateRetro(ctx *gin.Context) {
 	type Req struct {
 		Name string `json:"name"        binding:"required"`
 		TID  int64  `json:"template_id" binding:"required"`
+		service.RetroOptions
 	}
 
 	var req Req
@@ -230,7 +267,7 @@ func (h *RetroHandler) CreateRetro(ctx *gin.Context) {
 		return
 	}
 
-	r, err := h.svc.CreateRetro(ctx, req.TID, uid.(int64), req.Name)
+	r, err := h.svc.CreateRetro(ctx, req.TID, uid.(int64), req.Name, req.RetroOptions)
 	if err != nil {
 		slog.Error("create retro", "err", err)
 		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
@@ -244,8 +281,15 @@ func (h *RetroHandler) CreateRetro(ctx *gin.Context) {
 }
 
 func (h *RetroHandler) GetRetros(ctx *gin.Context) {
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
 	// TODO: Pagination not handled
-	t, err := h.svc.GetRetros(ctx)
+	t, err := h.svc.GetRetros(ctx, uid.(int64))
 	if err != nil {
 		slog.Error("get retros", "err", err)
 		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
@@ -279,7 +323,13 @@ func (h *RetroHandler) GetRetroByID(ctx *gin.Context) {
 		return
 	}
 
-	t, err := h.svc.GetRetroByID(ctx, int64(rid), uid.(int64))
+	var t repository.Retro
+	// ?comments=true for the discussion on the post-its as well
+	if ctx.Query("comments") == "true" {
+		t, err = h.svc.GetRetroWithComments(ctx, int64(rid), uid.(int64))
+	} else {
+		t, err = h.svc.GetRetroByID(ctx, int64(rid), uid.(int64))
+	}
 	switch err {
 	case nil:
 		ctx.JSON(http.StatusOK, Result{
@@ -357,6 +407,257 @@ func (h *RetroHandler) DeleteRetroByID(ctx *gin.Context) {
 	}
 }
 
+// ChangePhase moves the retro to the next or the previous phase
+func (h *RetroHandler) ChangePhase(ctx *gin.Context) {
+	type Req struct {
+		Phase string `json:"phase" binding:"required"`
+	}
+
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err = h.svc.ChangePhase(ctx, int64(rid), uid.(int64), req.Phase)
+	switch err {
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrInvalidPhase:
+		slog.Error("invalid phase", "phase", req.Phase, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "change phase success",
+		})
+		return
+	default:
+		slog.Error("change phase", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
+// RevealRetro makes all the post-its of the retro visible
+func (h *RetroHandler) RevealRetro(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err = h.svc.RevealRetro(ctx, int64(rid), uid.(int64))
+	switch err {
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "reveal retro success",
+		})
+		return
+	default:
+		slog.Error("reveal retro", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
+// ControlTimer starts, pauses, resumes, extends or stops the countdown of the
+// retro
+func (h *RetroHandler) ControlTimer(ctx *gin.Context) {
+	type Req struct {
+		Action      string `json:"action"       binding:"required"`
+		Seconds     int    `json:"seconds"      binding:"min=0"`
+		AutoAdvance bool   `json:"auto_advance"`
+	}
+
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	var req Req
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	t, err := h.svc.ControlTimer(ctx, int64(rid), uid.(int64), service.TimerCommand{
+		Action:      req.Action,
+		Seconds:     req.Seconds,
+		AutoAdvance: req.AutoAdvance,
+	})
+	switch err {
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrInvalidTimer:
+		slog.Error("invalid timer", "action", req.Action, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrTimerState:
+		slog.Error("timer state", "action", req.Action, "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "control timer success",
+			Data: t,
+		})
+		return
+	default:
+		slog.Error("control timer", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
+// GetMyVotes returns the votes of the user in the retro and what is left
+func (h *RetroHandler) GetMyVotes(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	votes, err := h.svc.GetMyVotes(ctx, int64(rid), uid.(int64))
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "get votes success",
+			Data: votes,
+		})
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	default:
+		slog.Error("get votes", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
 func (h *RetroHandler) GetTopVotePostits(ctx *gin.Context) {
 	nStr := ctx.Query("n")
 	n, err := strconv.Atoi(nStr)
@@ -381,8 +682,29 @@ func (h *RetroHandler) GetTopVotePostits(ctx *gin.Context) {
 		return
 	}
 
-	postits, err := h.svc.GetTopVotePostits(ctx, int64(rid), n)
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	postits, err := h.svc.GetTopVotePostits(ctx, int64(rid), uid.(int64), n)
 	switch err {
+	case service.ErrVotesHidden:
+		slog.Error("votes hidden", "id", rid, "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
 	case service.ErrIDNotFound:
 		slog.Error("retro id not found", "id", rid, "err", err)
 		ctx.JSON(http.StatusBadRequest, Result{
@@ -426,17 +748,40 @@ func (h *RetroHandler) CreatePostit(ctx *gin.Context) {
 	}
 
 	r, err := h.svc.CreatePostit(ctx, req, uid.(int64))
-	if err != nil {
+	switch err {
+	case service.ErrWrongPhase:
+		slog.Error("wrong phase", "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("question id not found", "id", req.QuestionID, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "create postit success",
+			Data: r, // ID, Name
+		})
+		return
+	default:
 		slog.Error("create postit", "err", err)
 		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
 		return
 	}
-
-	ctx.JSON(http.StatusOK, Result{
-		Code: CodeOK,
-		Msg:  "create postit success",
-		Data: r, // ID, Name
-	})
 }
 
 func (h *RetroHandler) UpdatePostitByID(ctx *gin.Context) {
@@ -473,6 +818,13 @@ func (h *RetroHandler) UpdatePostitByID(ctx *gin.Context) {
 
 	_, err = h.svc.UpdatePostit(ctx, int64(pid), req, uid.(int64))
 	switch err {
+	case service.ErrWrongPhase:
+		slog.Error("wrong phase", "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
 	case service.ErrNoAccess:
 		slog.Error("no access", "err", err)
 		ctx.JSON(http.StatusForbidden, Result{
@@ -480,6 +832,13 @@ func (h *RetroHandler) UpdatePostitByID(ctx *gin.Context) {
 			Msg:  err.Error(),
 		})
 		return
+	case service.ErrOtherRetro:
+		slog.Error("wrong question", "id", req.QuestionID, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
 	case service.ErrIDNotFound:
 		slog.Error("prostit id not found", "id", pid, "err", err)
 		ctx.JSON(http.StatusBadRequest, Result{
@@ -562,8 +921,29 @@ func (h *RetroHandler) VotePostitByID(ctx *gin.Context) {
 		return
 	}
 
-	err = h.svc.VotePostitByID(ctx, int64(pid))
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err = h.svc.VotePostitByID(ctx, int64(pid), uid.(int64))
 	switch err {
+	case service.ErrSelfVote:
+		slog.Error("self vote", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrWrongPhase, service.ErrNoVotesLeft, service.ErrPostitVoteLimit:
+		slog.Error("cannot vote", "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
 	case service.ErrNoAccess:
 		slog.Error("no access", "err", err)
 		ctx.JSON(http.StatusForbidden, Result{
@@ -591,6 +971,207 @@ func (h *RetroHandler) VotePostitByID(ctx *gin.Context) {
 	}
 }
 
+// UnvotePostitByID takes back one vote of the user
+func (h *RetroHandler) UnvotePostitByID(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	pid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong postit id", "id", pid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong postit id",
+		})
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	err = h.svc.UnvotePostitByID(ctx, int64(pid), uid.(int64))
+	switch err {
+	case service.ErrWrongPhase, service.ErrNoVote:
+		slog.Error("cannot unvote", "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("prostit id not found", "id", pid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "unvote for postit success",
+		})
+		return
+	default:
+		slog.Error("unvote for postit", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
+// MergePostits folds duplicate post-its into this one
+func (h *RetroHandler) MergePostits(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	pid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong postit id", "id", pid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong postit id",
+		})
+		return
+	}
+
+	var req service.PostitMerge
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	p, err := h.svc.MergePostits(ctx, int64(pid), req, uid.(int64))
+	switch err {
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrWrongPhase:
+		slog.Error("wrong phase", "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrInvalidMerge:
+		slog.Error("invalid merge", "id", pid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("prostit id not found", "id", pid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "merge postits success",
+			Data: p,
+		})
+		return
+	default:
+		slog.Error("merge postits", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
+// MovePostit changes the order of the post-it in its question
+func (h *RetroHandler) MovePostit(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	pid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong postit id", "id", pid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong postit id",
+		})
+		return
+	}
+
+	var req service.PostitMove
+
+	if err := ctx.Bind(&req); err != nil {
+		slog.Error("bad request", "err", err)
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	p, err := h.svc.MovePostit(ctx, int64(pid), req, uid.(int64))
+	switch err {
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrWrongPhase:
+		slog.Error("wrong phase", "err", err)
+		ctx.JSON(http.StatusConflict, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrInvalidPosition:
+		slog.Error("invalid position", "id", pid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("prostit id not found", "id", pid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "move postit success",
+			Data: p,
+		})
+		return
+	default:
+		slog.Error("move postit", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+}
+
 // TODO: Nice to have ...
 
 func (h *RetroHandler) AddPostitResolution(ctx *gin.Context) {
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chenmuyao/qooldown/internal/service"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// StreamRetroEvents sends the events of the retro as Server-Sent Events, for
+// the clients which cannot open a WebSocket.
+func (h *WebSocketHandler) StreamRetroEvents(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	// the browser resumes with the id of the last event it received,
+	// "<epoch>:<seq>"
+	var lastSeq int64
+	lastEventID := ctx.GetHeader("Last-Event-ID")
+	resume := lastEventID != ""
+	lastEpoch, lastSeqStr, _ := strings.Cut(lastEventID, ":")
+	if resume {
+		lastSeq, err = strconv.ParseInt(lastSeqStr, 10, 64)
+		if err != nil || lastSeq < 0 {
+			slog.Error("wrong last event id", "id", lastEventID, "err", err)
+			ctx.JSON(http.StatusBadRequest, Result{
+				Code: CodeUserSide,
+				Msg:  "wrong last event id",
+			})
+			return
+		}
+	}
+
+	_, err = h.svc.GetRetroByID(ctx, int64(rid), uid.(int64))
+	switch err {
+	case nil:
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	default:
+		slog.Error("get retro", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	u, err := h.userSvc.GetUserByID(ctx, uid.(int64))
+	if err != nil {
+		slog.Error("get sse user", "uid", uid, "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	slog.Info("sse client connected", "addr", ctx.Request.RemoteAddr, "retro", rid, "uid", uid)
+
+	// same as a WebSocket client, without the connection
+	c := newWsClient(nil, int64(rid), u.ID, u.Username)
+	c.lastSeq, c.resume = lastSeq, resume
+	c.lastEpoch = lastEpoch
+
+	h.hub.register <- c
+	defer func() {
+		h.hub.unregister <- c
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case response, ok := <-c.send:
+			if !ok {
+				// the hub dropped the client
+				return false
+			}
+
+			event := sse.Event{
+				Event: response.Action,
+				Data:  response,
+			}
+			// NOTE: only the board events can be resumed from, the greeting
+			// and the resync messages carry the current sequence number.
+			if response.Seq != 0 && response.Action != "" &&
+				response.Action != ActionResyncRequired {
+				event.Id = response.Epoch + ":" + strconv.FormatInt(response.Seq, 10)
+			}
+			ctx.Render(-1, event)
+			return true
+		case <-ticker.C:
+			// keep the proxies from closing the connection
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
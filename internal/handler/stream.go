@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"sort"
+
+	"github.com/chenmuyao/qooldown/internal/service"
+)
+
+const (
+	ActionResyncRequired = "resync_required"
+
+	// number of events kept per retro for the clients reconnecting
+	streamBufferSize = 256
+)
+
+type sequencedEvent struct {
+	seq   int64
+	epoch string
+	event service.Event
+}
+
+// retroStream numbers the events of a retro and keeps the last ones, so that
+// a client which lost its connection can get what it missed.
+type retroStream struct {
+	// the numbering of seq, the client resumes from a sequence number of the
+	// same epoch only
+	epoch string
+	seq   int64
+	buf   []sequencedEvent
+}
+
+// append keeps the event numbered by the broker.
+func (s *retroStream) append(seq int64, epoch string, e service.Event) {
+	if epoch != s.epoch {
+		// the numbering started over
+		s.epoch = epoch
+		s.buf = nil
+	}
+	s.seq = seq
+	s.buf = append(s.buf, sequencedEvent{seq: seq, epoch: epoch, event: e})
+	if len(s.buf) > streamBufferSize {
+		s.buf = s.buf[len(s.buf)-streamBufferSize:]
+	}
+}
+
+// since returns the events after lastSeq of the epoch. It returns false if
+// some of them are not in the buffer, or if lastSeq is unknown to the stream
+// (the numbering started over when the server restarted for example).
+func (s *retroStream) since(epoch string, lastSeq int64) ([]sequencedEvent, bool) {
+	if lastSeq != 0 && epoch != s.epoch {
+		return nil, false
+	}
+	if lastSeq > s.seq {
+		return nil, false
+	}
+	if lastSeq == s.seq {
+		return nil, true
+	}
+
+	i := sort.Search(len(s.buf), func(i int) bool {
+		return s.buf[i].seq > lastSeq
+	})
+	events := s.buf[i:]
+
+	// NOTE: the instance may have missed messages from the broker
+	next := lastSeq + 1
+	for _, e := range events {
+		if e.seq != next {
+			return nil, false
+		}
+		next++
+	}
+	if next-1 != s.seq {
+		return nil, false
+	}
+	return events, true
+}
+
+// stream returns the stream of the retro. Must be called from the Run
+// goroutine.
+func (hub *WsHub) stream(rid int64) *retroStream {
+	s, ok := hub.streams[rid]
+	if !ok {
+		s = &retroStream{}
+		hub.streams[rid] = s
+	}
+	return s
+}
+
+// replay sends the client the events it missed since its last sequence
+// number, or asks it to reload the whole retro if they are not available.
+// Must be called from the Run goroutine.
+func (hub *WsHub) replay(c *wsClient) {
+	events, ok := hub.stream(c.retroID).since(c.lastEpoch, c.lastSeq)
+	if ok && len(events) >= cap(c.send)-len(c.send) {
+		// would not fit in the queue anyway
+		ok = false
+	}
+	if !ok {
+		hub.sendTo(c, WsJSONResponse{
+			Action:  ActionResyncRequired,
+			RetroID: c.retroID,
+			Seq:     hub.stream(c.retroID).seq,
+			Epoch:   hub.stream(c.retroID).epoch,
+		})
+		return
+	}
+
+	for _, e := range events {
+		hub.sendTo(c, eventResponse(c, e))
+	}
+}
+
+// eventResponse builds the message of the event as the client is allowed to
+// see it.
+func eventResponse(c *wsClient, e sequencedEvent) WsJSONResponse {
+	view := e.event.ViewFor(c.uid)
+	return WsJSONResponse{
+		Action:     string(view.Type),
+		RetroID:    view.RetroID,
+		QuestionID: view.QuestionID,
+		Postit:     view.Postit,
+		Postits:    view.Postits,
+		Group:      view.Group,
+		MergedIDs:  view.MergedIDs,
+		Phase:      view.Phase,
+		Timer:      view.Timer,
+		Members:    view.Members,
+		Comment:    view.Comment,
+		Seq:        e.seq,
+		Epoch:      e.epoch,
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import "time"
+
+// Ephemeral actions, relayed to the other people of the retro but never
+// saved nor replayed.
+const (
+	ActionTypingStart = "typing_start"
+	ActionTypingStop  = "typing_stop"
+	ActionCursorMove  = "cursor_move"
+
+	// the user is doing something, only updates the presence
+	ActionActive = "active"
+)
+
+// minimum time between two relayed messages of a client, per action
+var relayThrottle = map[string]time.Duration{
+	ActionTypingStart: time.Second,
+	ActionCursorMove:  100 * time.Millisecond,
+}
+
+// Cursor is the position of the pointer on the board, relative to its size.
+type Cursor struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// throttled tells if the message comes too early after the previous one of the
+// same kind. Must only be called from the readPump of the client.
+func (c *wsClient) throttled(p WsPayload, now time.Time) bool {
+	period, ok := relayThrottle[p.Action]
+	if !ok {
+		return false
+	}
+
+	// typing in another column is a new information
+	key := relayKey{action: p.Action, questionID: p.QuestionID}
+	if now.Sub(c.lastRelayed[key]) < period {
+		return true
+	}
+	c.lastRelayed[key] = now
+	return false
+}
+
+type relayKey struct {
+	action     string
+	questionID int64
+}
@@ -1,30 +1,52 @@
 package handler
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/chenmuyao/qooldown/internal/repository"
+	"github.com/chenmuyao/qooldown/internal/service"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 )
 
-type WebSocketHandler struct{}
+type WebSocketHandler struct {
+	svc     service.RetroService
+	userSvc service.UserService
+	hub     *WsHub
+	limits  WsLimits
+}
 
-func NewWebSocketHandler() *WebSocketHandler {
-	return &WebSocketHandler{}
+func NewWebSocketHandler(
+	svc service.RetroService,
+	userSvc service.UserService,
+	hub *WsHub,
+	limits WsLimits,
+) *WebSocketHandler {
+	return &WebSocketHandler{
+		svc:     svc,
+		userSvc: userSvc,
+		hub:     hub,
+		limits:  limits,
+	}
 }
 
 func (h *WebSocketHandler) RegisterRoutes(server *gin.Engine) {
 	server.GET("/ws", h.WsEndPoint)
-}
-
-type WebSocketConnection struct {
-	*websocket.Conn
+	server.GET("/retros/:id/participants", h.GetParticipants)
+	server.GET("/retros/:id/events", h.StreamRetroEvents)
 }
 
 type WsPayload struct {
-	Conn   WebSocketConnection `json:"-"`
-	Action string              `json:"action"`
+	Client     *wsClient `json:"-"`
+	Action     string    `json:"action"`
+	QuestionID int64     `json:"question_id"`
+	Cursor     *Cursor   `json:"cursor"`
 	// UserID int                 `json:"user_id"`
 	// Message     string              `json:"message"`
 	// UserName    string              `json:"user_name"`
@@ -32,9 +54,30 @@ type WsPayload struct {
 }
 
 type WsJSONResponse struct {
-	Action  string `json:"action"`
-	Message string `json:"message"`
-	// UserID  int    `json:"user_id"`
+	Action     string                  `json:"action"`
+	Message    string                  `json:"message"`
+	RetroID    int64                   `json:"retro_id"`
+	QuestionID int64                   `json:"question_id"`
+	Postit     *repository.Postit      `json:"postit"`
+	Postits    []repository.Postit     `json:"postits"`
+	Group      *repository.PostitGroup `json:"group"`
+	MergedIDs  []int64                 `json:"merged_ids"`
+	Phase      string                  `json:"phase"`
+	Timer      *service.TimerState     `json:"timer"`
+	Members    []repository.RetroUser  `json:"members"`
+	Comment    *repository.Comment     `json:"comment"`
+
+	// sender of the ephemeral messages
+	UserID   int64   `json:"user_id"`
+	Username string  `json:"username"`
+	Cursor   *Cursor `json:"cursor"`
+
+	// sequence number of the retro event and its numbering, to resume with
+	// last_seq and epoch
+	Seq   int64  `json:"seq"`
+	Epoch string `json:"epoch"`
+
+	Participant *Participant `json:"participant"`
 }
 
 var upgradeConnection = websocket.Upgrader{
@@ -44,84 +87,227 @@ var upgradeConnection = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-var clients = make(map[WebSocketConnection]string)
+// wsAuthProtocol is the subprotocol a browser announces when it sends its
+// token in Sec-WebSocket-Protocol, as it cannot set an Authorization header:
+//
+//	new WebSocket(url, ["bearer", token])
+const wsAuthProtocol = "bearer"
+
+var errNoWsToken = errors.New("no token in ws handshake")
+
+// {{{ Auth
+
+// wsToken gets the JWT from the handshake, either from the Authorization
+// header or from the Sec-WebSocket-Protocol header. It also returns the
+// subprotocol to send back to the client, if any. The token is never read from
+// the query, which the access log writes down.
+func wsToken(ctx *gin.Context) (string, string, error) {
+	// Authorization: Bearer XXXX, for the clients that can set it
+	segs := strings.Split(ctx.GetHeader("Authorization"), " ")
+	if len(segs) == 2 && segs[0] == "Bearer" {
+		return segs[1], "", nil
+	}
+
+	// Sec-WebSocket-Protocol: bearer, XXXX
+	protocols := websocket.Subprotocols(ctx.Request)
+	if len(protocols) == 2 && protocols[0] == wsAuthProtocol {
+		return protocols[1], wsAuthProtocol, nil
+	}
+
+	return "", "", errNoWsToken
+}
+
+// parseWsToken parses the token the same way as the login middleware does
+// for the REST API. The expiration is required to close the connection in
+// time. A guest token is accepted as well, with the only retro the guest may
+// join.
+func parseWsToken(tokenStr string) (UserClaims, int64, error) {
+	var uc UserClaims
+	token, err := jwt.ParseWithClaims(tokenStr, &uc, func(t *jwt.Token) (interface{}, error) {
+		return JWTKey, nil
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		gc, err := ParseGuestToken(tokenStr)
+		if err != nil {
+			return UserClaims{}, 0, err
+		}
+		return gc.UserClaims, gc.RetroID, nil
+	}
+
+	if !token.Valid {
+		return UserClaims{}, 0, jwt.ErrTokenInvalidClaims
+	}
+
+	return uc, 0, nil
+}
 
-var wsChan = make(chan WsPayload)
+// closeOnExpire closes the connection with a policy violation once the token
+// used to open it expires. The returned timer must be stopped when the
+// connection ends.
+func closeOnExpire(c *wsClient, expireTime time.Time) *time.Timer {
+	return time.AfterFunc(time.Until(expireTime), func() {
+		slog.Info("ws token expired", "uid", c.uid, "retro", c.retroID)
+		c.closeWith(websocket.ClosePolicyViolation, "token expired")
+	})
+}
 
-// TODO: 1. Add retro id and user control. One id, when connection
-// Cache to hold retroID:clients pair.
+// }}}
 
 func (h *WebSocketHandler) WsEndPoint(ctx *gin.Context) {
-	ws, err := upgradeConnection.Upgrade(ctx.Writer, ctx.Request, nil)
+	// /ws?retro_id=1&last_seq=42&epoch=XXXX
+	tokenStr, protocol, err := wsToken(ctx)
 	if err != nil {
-		slog.Error("ws upgrade connection error", "err", err)
+		// not logged in
+		ctx.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	slog.Info("ws client connected", "addr", ctx.Request.RemoteAddr)
-	var response WsJSONResponse
-	response.Message = "Connected to server"
-
-	err = ws.WriteJSON(response)
+	uc, guestRetro, err := parseWsToken(tokenStr)
 	if err != nil {
-		slog.Error("ws writejson error", "err", err)
+		// token cannot be parsed or unauthorized
+		ctx.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	conn := WebSocketConnection{Conn: ws}
-	clients[conn] = ""
+	ridStr := ctx.Query("retro_id")
+	rid, err := strconv.Atoi(ridStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", ridStr, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
 
-	go h.ListenForWS(&conn)
-}
+	if guestRetro != 0 && guestRetro != int64(rid) {
+		// guests only join their retro
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return
+	}
 
-func (h *WebSocketHandler) ListenForWS(conn *WebSocketConnection) {
-	defer func() {
-		if r := recover(); r != nil {
-			slog.Error("ws panic", "err", r)
+	// the client reconnects and wants the events it missed
+	var lastSeq int64
+	lastSeqStr, resume := ctx.GetQuery("last_seq")
+	if resume {
+		lastSeq, err = strconv.ParseInt(lastSeqStr, 10, 64)
+		if err != nil || lastSeq < 0 {
+			slog.Error("wrong last seq", "seq", lastSeqStr, "err", err)
+			ctx.JSON(http.StatusBadRequest, Result{
+				Code: CodeUserSide,
+				Msg:  "wrong last seq",
+			})
+			return
 		}
-	}()
+	}
 
-	var payload WsPayload
+	_, err = h.svc.GetRetroByID(ctx, int64(rid), uc.UID)
+	switch err {
+	case nil:
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	default:
+		slog.Error("get retro", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
 
-	for {
-		err := conn.ReadJSON(&payload)
-		if err != nil {
-			// do nothing
-			slog.Error("ws read json error", "err", err)
-			break
-		}
-		payload.Conn = *conn
-		wsChan <- payload
+	u, err := h.userSvc.GetUserByID(ctx, uc.UID)
+	if err != nil {
+		slog.Error("get ws user", "uid", uc.UID, "err", err)
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var header http.Header
+	if protocol != "" {
+		// the browser drops the connection if the protocol is not echoed
+		header = http.Header{"Sec-Websocket-Protocol": []string{protocol}}
 	}
-}
 
-func (h *WebSocketHandler) ListenToWsChannel() {
-	var response WsJSONResponse
-	for {
-		e := <-wsChan
-		response.Action = e.Action
-		slog.Info("ws server received", "action", e.Action)
-		h.broadcastToAll(response)
-
-		// switch e.Action {
-		// case "deleteUser":
-		// 	response.Action = "logout"
-		// 	response.Message = "Your account has ben deleted"
-		// 	response.UserID = e.UserID
-		// 	h.broadcastToAll(response)
-		// default:
-		// }
+	ws, err := upgradeConnection.Upgrade(ctx.Writer, ctx.Request, header)
+	if err != nil {
+		slog.Error("ws upgrade connection error", "err", err)
+		return
 	}
+
+	slog.Info(
+		"ws client connected",
+		"addr", ctx.Request.RemoteAddr,
+		"retro", rid,
+		"uid", uc.UID,
+	)
+	c := newWsClient(ws, int64(rid), uc.UID, u.Username)
+	c.lastSeq, c.resume = lastSeq, resume
+	c.lastEpoch = ctx.Query("epoch")
+	c.limiter = h.limits.newLimiter()
+	ws.SetReadLimit(h.limits.MaxMessageSize)
+	c.expire = closeOnExpire(c, uc.ExpiresAt.Time)
+
+	h.hub.register <- c
+
+	go c.writePump()
+	go c.readPump(h.hub)
 }
 
-func (app *WebSocketHandler) broadcastToAll(response WsJSONResponse) {
-	for client := range clients {
-		// broadcast to every connected client
-		err := client.WriteJSON(response)
-		if err != nil {
-			slog.Error("ws error on action", "action", response.Action, "err", err)
-			_ = client.Close()
-			delete(clients, client)
-		}
+// GetParticipants returns the users connected to the retro
+func (h *WebSocketHandler) GetParticipants(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+
+	rid, err := strconv.Atoi(idStr)
+	if err != nil {
+		slog.Error("wrong retro id", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "wrong retro id",
+		})
+		return
+	}
+
+	uid, ok := ctx.Get("uid")
+	if !ok {
+		slog.Error("cannot get user id")
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
+	}
+
+	_, err = h.svc.GetRetroByID(ctx, int64(rid), uid.(int64))
+	switch err {
+	case nil:
+		ctx.JSON(http.StatusOK, Result{
+			Code: CodeOK,
+			Msg:  "get participants success",
+			Data: h.hub.Participants(int64(rid)),
+		})
+	case service.ErrNoAccess:
+		slog.Error("no access", "err", err)
+		ctx.JSON(http.StatusForbidden, Result{
+			Code: CodeUserSide,
+			Msg:  err.Error(),
+		})
+		return
+	case service.ErrIDNotFound:
+		slog.Error("retro id not found", "id", rid, "err", err)
+		ctx.JSON(http.StatusBadRequest, Result{
+			Code: CodeUserSide,
+			Msg:  "id not found",
+		})
+		return
+	default:
+		slog.Error("get participants", "err", err)
+		ctx.JSON(http.StatusInternalServerError, InternalServerErrorResult)
+		return
 	}
 }
@@ -3,6 +3,7 @@ package middleware
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,15 +14,22 @@ import (
 
 type LoginJWT struct {
 	ignorePaths map[string]struct{}
+	// "METHOD /route" allowed with a guest token
+	guestRoutes map[string]struct{}
 }
 
-func NewLoginJWT(ignorePaths []string) *LoginJWT {
+func NewLoginJWT(ignorePaths []string, guestRoutes []string) *LoginJWT {
 	ignorePathsMap := make(map[string]struct{}, len(ignorePaths))
 	for _, path := range ignorePaths {
 		ignorePathsMap[path] = struct{}{}
 	}
+	guestRoutesMap := make(map[string]struct{}, len(guestRoutes))
+	for _, route := range guestRoutes {
+		guestRoutesMap[route] = struct{}{}
+	}
 	return &LoginJWT{
 		ignorePaths: ignorePathsMap,
+		guestRoutes: guestRoutesMap,
 	}
 }
 
@@ -54,8 +62,8 @@ func (m *LoginJWT) CheckLogin() gin.HandlerFunc {
 			return handler.JWTKey, nil
 		})
 		if err != nil {
-			// token cannot be parsed
-			ctx.AbortWithStatus(http.StatusUnauthorized)
+			// token cannot be parsed, maybe a guest
+			m.checkGuest(ctx, tokenStr)
 			return
 		}
 
@@ -78,3 +86,28 @@ func (m *LoginJWT) CheckLogin() gin.HandlerFunc {
 		ctx.Set("uid", uc.UID)
 	}
 }
+
+// checkGuest lets a guest use the routes allowed to guests, on its retro only.
+func (m *LoginJWT) checkGuest(ctx *gin.Context, tokenStr string) {
+	gc, err := handler.ParseGuestToken(tokenStr)
+	if err != nil {
+		// token cannot be parsed
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	route := ctx.FullPath()
+	if _, ok := m.guestRoutes[ctx.Request.Method+" "+route]; !ok {
+		// needs an account
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if strings.HasPrefix(route, "/retros/:id") &&
+		ctx.Param("id") != strconv.FormatInt(gc.RetroID, 10) {
+		// another retro
+		ctx.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	ctx.Set("uid", gc.UID)
+}
@@ -9,6 +9,11 @@ func InitTable(db *gorm.DB) error {
 		&TemplateQuestion{},
 		&Retro{},
 		&Postit{},
+		&PostitOrigin{},
 		&Question{},
+		&PostitGroup{},
+		&Vote{},
+		&RetroUser{},
+		&Comment{},
 	)
 }
@@ -2,12 +2,22 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var ErrIDNotFound = gorm.ErrRecordNotFound
+var (
+	ErrIDNotFound = gorm.ErrRecordNotFound
+	ErrNoVote     = errors.New("no vote to take back")
+
+	ErrNoVotesLeft     = errors.New("no votes left")
+	ErrPostitVoteLimit = errors.New("too many votes on this post-it")
+
+	ErrInvalidPosition = errors.New("neighbours of the post-it out of order")
+)
 
 type Template struct {
 	CreatedAt time.Time      `json:"created_at"`
@@ -17,6 +27,8 @@ type Template struct {
 
 	Name string `gorm:"index" json:"name"`
 
+	VotingRules
+
 	// belongs to
 	UserID int64 `json:"owner_id"`
 	User   User  `json:"owner"`
@@ -25,6 +37,18 @@ type Template struct {
 	Questions []TemplateQuestion `json:"questions"`
 }
 
+// VotingRules are set on the template and copied to its retros.
+type VotingRules struct {
+	// 0 for no limit
+	VotesPerUser int `json:"votes_per_user"`
+	// users may vote for their own post-its, true if not set
+	AuthorizeSelfVote *bool `json:"authorize_self_vote" gorm:"default:true"`
+	// maximum votes of a user on a single post-it, 0 for no limit
+	MaxVotesPerPostit int `json:"max_votes_per_postit"`
+	// the votes of the others are only visible after the vote phase
+	HideVotesDuringVote bool `json:"hide_votes_during_vote"`
+}
+
 type TemplateQuestion struct {
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
@@ -48,10 +72,43 @@ type Question struct {
 	// fk
 	RetroID int64 `json:"retro_id"`
 
-	// has many
+	// has many, the ones of the groups are in their group only
 	Postits []Postit `json:"postIts"`
+
+	// has many
+	Groups []PostitGroup `json:"groups"`
+}
+
+// PostitGroup is a cluster of similar post-its of a question.
+type PostitGroup struct {
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	ID        int64          `json:"id"         gorm:"primarykey;autoIncrement"`
+
+	Title string `json:"title"`
+
+	// belongs to
+	QuestionID int64 `json:"question_id" gorm:"index"`
+
+	// sum of the votes of the users on the group as a whole
+	Votes int `json:"votes"`
+	// votes of the group and of its post-its
+	TotalVotes int `json:"total_votes" gorm:"-"`
+
+	// has many, ordered
+	Postits []Postit `json:"postits" gorm:"foreignKey:GroupID"`
 }
 
+// Phases of a retro, in order
+const (
+	PhaseWrite   = "write"
+	PhaseGroup   = "group"
+	PhaseVote    = "vote"
+	PhaseDiscuss = "discuss"
+	PhaseClosed  = "closed"
+)
+
 type Retro struct {
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
@@ -60,8 +117,19 @@ type Retro struct {
 
 	Name string `gorm:"index" json:"name"`
 
-	// // many to many
-	// Users []User `gorm:"many2many:retro_users;"`
+	Phase string `json:"phase" gorm:"default:write"`
+
+	// the content of the others is hidden until the owner reveals it, whatever
+	// the visibility of the post-its
+	HiddenUntilReveal bool `json:"hidden_until_reveal"`
+	Revealed          bool `json:"revealed"`
+
+	VotingRules
+
+	Timer
+
+	// has many
+	Members []RetroUser `json:"members"`
 
 	// belongs to
 	UserID int64 `json:"owner_id"`
@@ -72,6 +140,36 @@ type Retro struct {
 	Questions []Question `json:"questions"`
 }
 
+// Roles of the members of a retro
+const (
+	RoleOwner       = "owner"
+	RoleFacilitator = "facilitator"
+	RoleParticipant = "participant"
+	// read only
+	RoleObserver = "observer"
+)
+
+// RetroUser is the membership of a user in a retro.
+type RetroUser struct {
+	RetroID   int64     `json:"retro_id"  gorm:"primaryKey"`
+	UserID    int64     `json:"user_id"   gorm:"primaryKey"`
+	CreatedAt time.Time `json:"joined_at"`
+
+	Role string `json:"role" gorm:"default:participant"`
+
+	// belongs to
+	User User `json:"user"`
+}
+
+// Timer is the countdown of the facilitator. It is either stopped, running
+// until TimerEndsAt, or paused with TimerRemainingMS left.
+type Timer struct {
+	TimerEndsAt      *time.Time `json:"timer_ends_at"`
+	TimerRemainingMS int64      `json:"timer_remaining_ms"`
+	// move to the next phase when the countdown ends
+	TimerAutoAdvance bool `json:"timer_auto_advance"`
+}
+
 type Postit struct {
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
@@ -85,11 +183,79 @@ type Postit struct {
 	// belongs to
 	QuestionID int64 `json:"question_id"`
 
+	// order in the question, fractional so that a move only changes the
+	// moved post-it
+	Position float64 `json:"position"`
+
+	// the group of the question the post-it is in, if any
+	GroupID       *int64 `json:"group_id"       gorm:"index"`
+	GroupPosition int    `json:"group_position"`
+
+	// sum of the votes of the users
 	Votes int `json:"votes"`
 
 	// Content
 	Content   string `json:"content"`
 	IsVisible bool   `json:"is_visible"`
+
+	// the post-its merged into this one
+	// has many
+	Origins []PostitOrigin `json:"origins"`
+
+	// the discussion on the post-it, only loaded when asked
+	// has many
+	Comments []Comment `json:"comments"`
+}
+
+// PostitOrigin records a post-it merged into another one, with its author.
+type PostitOrigin struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        int64     `json:"id"         gorm:"primarykey;autoIncrement"`
+
+	// fk, the post-it it was merged into
+	PostitID int64 `json:"postit_id" gorm:"index"`
+
+	// the merged post-it
+	OriginalID int64  `json:"original_id"`
+	Content    string `json:"content"`
+
+	// belongs to
+	UserID int64 `json:"owner_id"`
+	User   User  `json:"owner"`
+}
+
+// Comment is a message of the discussion on a post-it.
+type Comment struct {
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+	ID        int64          `json:"id"         gorm:"primarykey;autoIncrement"`
+
+	// belongs to
+	PostitID int64 `json:"postit_id" gorm:"index"`
+
+	// belongs to
+	UserID int64 `json:"owner_id"`
+	User   User  `json:"owner"`
+
+	Body string `json:"body"`
+	// nil until the author changes the body
+	EditedAt *time.Time `json:"edited_at"`
+}
+
+// Vote is the number of votes of a user on a post-it, or on a group.
+type Vote struct {
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ID        int64     `json:"id"         gorm:"primarykey;autoIncrement"`
+
+	// one row per user and post-it or group, so that the count can be
+	// incremented in place. The other ID is 0.
+	UserID   int64 `json:"user_id"   gorm:"uniqueIndex:idx_votes_user_target"`
+	PostitID int64 `json:"postit_id" gorm:"uniqueIndex:idx_votes_user_target;index"`
+	GroupID  int64 `json:"group_id"  gorm:"uniqueIndex:idx_votes_user_target;index"`
+
+	Count int `json:"count"`
 }
 
 type RetroRepository interface {
@@ -99,17 +265,52 @@ type RetroRepository interface {
 	GetTemplateByID(ctx context.Context, tid int64) (Template, error)
 	DeleteTemplateByID(ctx context.Context, tid int64) error
 
-	CreateRetro(ctx context.Context, tid int64, uid int64, name string) (Retro, error)
-	GetRetros(ctx context.Context) ([]Retro, error)
+	CreateRetro(ctx context.Context, tid int64, retro Retro) (Retro, error)
+	GetRetros(ctx context.Context, uid int64) ([]Retro, error)
 	GetRetroByID(ctx context.Context, rid int64) (Retro, error)
+	GetRetroByQuestionID(ctx context.Context, qid int64) (Retro, error)
 	DeleteRetroByID(ctx context.Context, rid int64) error
+	UpdateRetroPhase(ctx context.Context, rid int64, phase string) error
+	RevealRetro(ctx context.Context, rid int64) error
+	UpdateRetroTimer(ctx context.Context, rid int64, t Timer) error
+	ExpireRetroTimer(ctx context.Context, rid int64, now time.Time) (bool, error)
+	GetRetrosWithTimer(ctx context.Context) ([]Retro, error)
+	AddRetroMember(ctx context.Context, rid int64, uid int64, role string) error
+	GetRetroMember(ctx context.Context, rid int64, uid int64) (RetroUser, error)
+	CountRetroGuests(ctx context.Context, rid int64) (int64, error)
+	SetRetroMemberRole(ctx context.Context, rid int64, uid int64, role string) error
+	SetRetroFacilitator(ctx context.Context, rid int64, uid int64) error
+
+	GetQuestionByID(ctx context.Context, qid int64) (Question, error)
 
 	CreatePostit(ctx context.Context, p Postit) (Postit, error)
 	GetPostitByID(ctx context.Context, pid int64) (Postit, error)
 	DeletePostitByID(ctx context.Context, pid int64) error
 	UpdatePostit(ctx context.Context, p Postit) (Postit, error)
-	VotePostitByID(ctx context.Context, pid int64) error
+	MovePostit(ctx context.Context, pid int64, after int64, before int64) (bool, error)
+	GetPostitsByQuestionID(ctx context.Context, qid int64) ([]Postit, error)
+	GetRetroComments(ctx context.Context, rid int64) ([]Comment, error)
+	MergePostits(ctx context.Context, target int64, pids []int64, content string) error
+	VotePostitByID(ctx context.Context, rid int64, pid int64, uid int64, limits VoteLimits) error
+	UnvotePostitByID(ctx context.Context, pid int64, uid int64) error
+	GetUserVotes(ctx context.Context, rid int64, uid int64) ([]Vote, error)
+	GetRetroVotes(ctx context.Context, rid int64) ([]Vote, error)
 	GetTopVotePostits(ctx context.Context, rid int64, n int) ([]Postit, error)
+
+	CreateGroup(ctx context.Context, g PostitGroup, pids []int64) (PostitGroup, error)
+	GetGroupByID(ctx context.Context, gid int64) (PostitGroup, error)
+	RenameGroup(ctx context.Context, gid int64, title string) error
+	AddPostitToGroup(ctx context.Context, gid int64, pid int64) error
+	RemovePostitFromGroup(ctx context.Context, pid int64) error
+	DeleteGroupByID(ctx context.Context, gid int64) error
+	VoteGroupByID(ctx context.Context, rid int64, gid int64, uid int64, limits VoteLimits) error
+	UnvoteGroupByID(ctx context.Context, gid int64, uid int64) error
+
+	CreateComment(ctx context.Context, c Comment) (Comment, error)
+	GetCommentByID(ctx context.Context, cid int64) (Comment, error)
+	GetCommentsByPostitID(ctx context.Context, pid int64) ([]Comment, error)
+	UpdateComment(ctx context.Context, c Comment) (Comment, error)
+	DeleteCommentByID(ctx context.Context, cid int64) error
 }
 
 type GORMRetroRepository struct {
@@ -159,13 +360,13 @@ func (repo *GORMRetroRepository) DeleteTemplateByID(ctx context.Context, tid int
 // }}}
 // {{{ Retro
 
+// CreateRetro creates the retro with the questions of the template. The retro
+// carries the name, the owner and the settings.
 func (repo *GORMRetroRepository) CreateRetro(
 	ctx context.Context,
 	tid int64,
-	uid int64,
-	name string,
+	retro Retro,
 ) (Retro, error) {
-	var retro Retro
 	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		// Get template
 		dao := NewRetroRepository(tx)
@@ -174,8 +375,7 @@ func (repo *GORMRetroRepository) CreateRetro(
 			return err
 		}
 
-		retro.Name = name
-		retro.UserID = uid
+		retro.Phase = PhaseWrite
 
 		for _, qt := range t.Questions {
 			var q Question
@@ -189,15 +389,28 @@ func (repo *GORMRetroRepository) CreateRetro(
 			return err
 		}
 
-		return nil
+		// the creator is the first member
+		return tx.Create(&RetroUser{
+			RetroID: retro.ID,
+			UserID:  retro.UserID,
+			Role:    RoleOwner,
+		}).Error
 	})
 
 	return retro, err
 }
 
-func (repo *GORMRetroRepository) GetRetros(ctx context.Context) ([]Retro, error) {
+// GetRetros returns the retros the user is a member of.
+func (repo *GORMRetroRepository) GetRetros(ctx context.Context, uid int64) ([]Retro, error) {
 	var r []Retro
-	err := repo.db.WithContext(ctx).Preload("User").Find(&r).Error
+	err := repo.db.WithContext(ctx).
+		Preload("User").
+		Where(
+			"user_id = ? OR id IN (?)",
+			uid,
+			repo.db.Model(&RetroUser{}).Select("retro_id").Where("user_id = ?", uid),
+		).
+		Find(&r).Error
 	return r, err
 }
 
@@ -205,33 +418,214 @@ func (repo *GORMRetroRepository) GetRetroByID(ctx context.Context, rid int64) (R
 	var r Retro
 	err := repo.db.WithContext(ctx).
 		Preload("User").
+		Preload("Members.User").
 		Preload("Questions", func(db *gorm.DB) *gorm.DB {
 			return db.Order("id ASC")
 		}).
 		Preload("Questions.Postits", func(db *gorm.DB) *gorm.DB {
-			return db.Order("created_at ASC")
+			return db.Where("group_id IS NULL").Order("position ASC, created_at ASC")
 		}).
 		Preload("Questions.Postits.User").
+		Preload("Questions.Postits.Origins.User").
+		Preload("Questions.Groups", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id ASC")
+		}).
+		Preload("Questions.Groups.Postits", func(db *gorm.DB) *gorm.DB {
+			return db.Order("group_position ASC")
+		}).
+		Preload("Questions.Groups.Postits.User").
+		Preload("Questions.Groups.Postits.Origins.User").
 		Where("id = ?", rid).First(&r).Error
 	return r, err
 }
 
+// GetRetroByQuestionID returns the retro of the question, without its
+// questions.
+func (repo *GORMRetroRepository) GetRetroByQuestionID(ctx context.Context, qid int64) (Retro, error) {
+	var r Retro
+	err := repo.db.WithContext(ctx).
+		Joins("JOIN questions ON questions.retro_id = retros.id").
+		Where("questions.id = ? AND questions.deleted_at IS NULL", qid).
+		First(&r).Error
+	return r, err
+}
+
 func (repo *GORMRetroRepository) DeleteRetroByID(ctx context.Context, rid int64) error {
 	err := repo.db.WithContext(ctx).Delete(&Retro{}, rid).Error
 	return err
 }
 
+func (repo *GORMRetroRepository) UpdateRetroPhase(ctx context.Context, rid int64, phase string) error {
+	res := repo.db.WithContext(ctx).Model(&Retro{}).Where("id = ?", rid).Update("phase", phase)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrIDNotFound
+	}
+	return nil
+}
+
+// RevealRetro makes all the post-its of the retro visible.
+func (repo *GORMRetroRepository) RevealRetro(ctx context.Context, rid int64) error {
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Retro{}).Where("id = ?", rid).Update("revealed", true)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrIDNotFound
+		}
+
+		return tx.Model(&Postit{}).
+			Where("question_id IN (?)", tx.Model(&Question{}).Select("id").Where("retro_id = ?", rid)).
+			Update("is_visible", true).
+			Error
+	})
+}
+
+func (repo *GORMRetroRepository) UpdateRetroTimer(ctx context.Context, rid int64, t Timer) error {
+	return repo.db.WithContext(ctx).
+		Model(&Retro{}).
+		Where("id = ?", rid).
+		Select("timer_ends_at", "timer_remaining_ms", "timer_auto_advance").
+		Updates(Retro{Timer: t}).
+		Error
+}
+
+// ExpireRetroTimer stops the timer if it is over. It tells if it was, as
+// several instances may try to expire the same timer.
+func (repo *GORMRetroRepository) ExpireRetroTimer(
+	ctx context.Context,
+	rid int64,
+	now time.Time,
+) (bool, error) {
+	res := repo.db.WithContext(ctx).
+		Model(&Retro{}).
+		Where("id = ? AND timer_ends_at <= ?", rid, now).
+		Select("timer_ends_at", "timer_remaining_ms", "timer_auto_advance").
+		Updates(Retro{})
+	return res.RowsAffected > 0, res.Error
+}
+
+// GetRetrosWithTimer returns the retros whose timer is running.
+func (repo *GORMRetroRepository) GetRetrosWithTimer(ctx context.Context) ([]Retro, error) {
+	var r []Retro
+	err := repo.db.WithContext(ctx).Where("timer_ends_at IS NOT NULL").Find(&r).Error
+	return r, err
+}
+
+// AddRetroMember makes the user a member of the retro with the role, if not
+// already.
+func (repo *GORMRetroRepository) AddRetroMember(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+	role string,
+) error {
+	return repo.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&RetroUser{RetroID: rid, UserID: uid, Role: role}).
+		Error
+}
+
+func (repo *GORMRetroRepository) GetRetroMember(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+) (RetroUser, error) {
+	var m RetroUser
+	err := repo.db.WithContext(ctx).
+		Where("retro_id = ? AND user_id = ?", rid, uid).
+		First(&m).
+		Error
+	return m, err
+}
+
+// CountRetroGuests returns the number of guests who joined the retro.
+func (repo *GORMRetroRepository) CountRetroGuests(ctx context.Context, rid int64) (int64, error) {
+	var n int64
+	err := repo.db.WithContext(ctx).
+		Model(&RetroUser{}).
+		Joins("JOIN users ON users.id = retro_users.user_id").
+		Where("retro_users.retro_id = ? AND users.guest = ?", rid, true).
+		Count(&n).
+		Error
+	return n, err
+}
+
+func (repo *GORMRetroRepository) SetRetroMemberRole(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+	role string,
+) error {
+	res := repo.db.WithContext(ctx).
+		Model(&RetroUser{}).
+		Where("retro_id = ? AND user_id = ?", rid, uid).
+		Update("role", role)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrIDNotFound
+	}
+	return nil
+}
+
+// SetRetroFacilitator makes the user the only facilitator of the retro. The
+// previous one becomes a participant. The role of the owner is kept.
+func (repo *GORMRetroRepository) SetRetroFacilitator(ctx context.Context, rid int64, uid int64) error {
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&RetroUser{}).
+			Where("retro_id = ? AND role = ?", rid, RoleFacilitator).
+			Update("role", RoleParticipant).
+			Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&RetroUser{}).
+			Where("retro_id = ? AND user_id = ? AND role <> ?", rid, uid, RoleOwner).
+			Update("role", RoleFacilitator).
+			Error
+	})
+}
+
+// }}}
+// {{{ Question
+
+func (repo *GORMRetroRepository) GetQuestionByID(ctx context.Context, qid int64) (Question, error) {
+	var q Question
+	err := repo.db.WithContext(ctx).Where("id = ?", qid).First(&q).Error
+	return q, err
+}
+
 // }}}
 // {{{ Postit
 
+// CreatePostit puts the post-it at the end of its question.
 func (repo *GORMRetroRepository) CreatePostit(ctx context.Context, p Postit) (Postit, error) {
-	err := repo.db.WithContext(ctx).Create(&p).Error
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		last, err := lastPosition(tx, p.QuestionID, 0)
+		if err != nil {
+			return err
+		}
+		p.Position = last + 1
+
+		return tx.Create(&p).Error
+	})
 	return p, err
 }
 
 func (repo *GORMRetroRepository) GetPostitByID(ctx context.Context, pid int64) (Postit, error) {
 	var p Postit
-	err := repo.db.WithContext(ctx).Preload("User").Where("id = ?", pid).First(&p).Error
+	err := repo.db.WithContext(ctx).
+		Preload("User").
+		Preload("Origins.User").
+		Where("id = ?", pid).
+		First(&p).
+		Error
 	return p, err
 }
 
@@ -241,28 +635,460 @@ func (repo *GORMRetroRepository) DeletePostitByID(ctx context.Context, pid int64
 }
 
 func (repo *GORMRetroRepository) UpdatePostit(ctx context.Context, p Postit) (Postit, error) {
-	err := repo.db.WithContext(ctx).Save(&p).Error
+	err := repo.db.WithContext(ctx).Omit(clause.Associations).Save(&p).Error
 	return p, err
 }
 
-func (repo *GORMRetroRepository) VotePostitByID(ctx context.Context, pid int64) error {
+// MovePostit puts the post-it between its new neighbours of the question, 0
+// for the top or the bottom, at the end if none. It tells if the post-its of
+// the question had to be renumbered, when there is no room left between the
+// neighbours.
+func (repo *GORMRetroRepository) MovePostit(
+	ctx context.Context,
+	pid int64,
+	after int64,
+	before int64,
+) (bool, error) {
+	renumbered := false
 	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		dao := NewRetroRepository(tx)
-		p, err := dao.GetPostitByID(ctx, pid)
+		var p Postit
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", pid).
+			First(&p).
+			Error
+		if err != nil {
+			return err
+		}
+
+		position, ok, err := positionBetween(tx, p.QuestionID, pid, after, before)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			err = renumberPostits(tx, p.QuestionID)
+			if err != nil {
+				return err
+			}
+			renumbered = true
+
+			position, ok, err = positionBetween(tx, p.QuestionID, pid, after, before)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrInvalidPosition
+			}
+		}
+
+		return tx.Model(&Postit{}).
+			Where("id = ?", pid).
+			Update("position", position).
+			Error
+	})
+	return renumbered, err
+}
+
+// GetPostitsByQuestionID returns the post-its of the question, in order.
+func (repo *GORMRetroRepository) GetPostitsByQuestionID(
+	ctx context.Context,
+	qid int64,
+) ([]Postit, error) {
+	var postits []Postit
+	err := repo.db.WithContext(ctx).
+		Preload("User").
+		Preload("Origins.User").
+		Where("question_id = ?", qid).
+		Order("position ASC, created_at ASC").
+		Find(&postits).
+		Error
+	return postits, err
+}
+
+// GetRetroComments returns the comments on the post-its of the retro, the
+// oldest first.
+func (repo *GORMRetroRepository) GetRetroComments(ctx context.Context, rid int64) ([]Comment, error) {
+	db := repo.db.WithContext(ctx)
+	sub := db.Session(&gorm.Session{NewDB: true})
+	questions := sub.Model(&Question{}).Select("id").Where("retro_id = ?", rid)
+	postits := sub.Model(&Postit{}).Select("id").Where("question_id IN (?)", questions)
+
+	var comments []Comment
+	err := db.
+		Preload("User").
+		Where("postit_id IN (?)", postits).
+		Order("created_at ASC").
+		Find(&comments).
+		Error
+	return comments, err
+}
+
+// positionBetween returns the position between the neighbours, or false if
+// there is no room between them.
+func positionBetween(
+	tx *gorm.DB,
+	qid int64,
+	pid int64,
+	after int64,
+	before int64,
+) (float64, bool, error) {
+	if after == 0 && before == 0 {
+		last, err := lastPosition(tx, qid, pid)
+		return last + 1, true, err
+	}
+
+	var a, b float64
+	var err error
+	if after != 0 {
+		a, err = neighbourPosition(tx, qid, after)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	if before != 0 {
+		b, err = neighbourPosition(tx, qid, before)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	switch {
+	case after == 0:
+		return b - 1, true, nil
+	case before == 0:
+		return a + 1, true, nil
+	}
+	position := a + (b-a)/2
+	return position, a < position && position < b, nil
+}
+
+func neighbourPosition(tx *gorm.DB, qid int64, pid int64) (float64, error) {
+	var p Postit
+	err := tx.Select("position").
+		Where("id = ? AND question_id = ?", pid, qid).
+		First(&p).
+		Error
+	return p.Position, err
+}
+
+// lastPosition returns the position at the bottom of the question, without
+// the post-it if set.
+func lastPosition(tx *gorm.DB, qid int64, pid int64) (float64, error) {
+	var last float64
+	err := tx.Model(&Postit{}).
+		Select("COALESCE(MAX(position), 0)").
+		Where("question_id = ? AND id <> ?", qid, pid).
+		Scan(&last).
+		Error
+	return last, err
+}
+
+// renumberPostits spreads the post-its of the question again, in the same
+// order. The post-its created before the positions all start at 0.
+func renumberPostits(tx *gorm.DB, qid int64) error {
+	var ids []int64
+	err := tx.Model(&Postit{}).
+		Where("question_id = ?", qid).
+		Order("position ASC, created_at ASC").
+		Pluck("id", &ids).
+		Error
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		err = tx.Model(&Postit{}).
+			Where("id = ?", id).
+			Update("position", float64(i+1)).
+			Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MergePostits folds the post-its into the target, in one go. Their votes and
+// comments go to the target and they are kept as its origins. The content of the target
+// is replaced if not empty.
+func (repo *GORMRetroRepository) MergePostits(
+	ctx context.Context,
+	target int64,
+	pids []int64,
+	content string,
+) error {
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, pid := range pids {
+			var p Postit
+			err := tx.Where("id = ?", pid).First(&p).Error
+			if err != nil {
+				return err
+			}
+
+			// the origins of the post-it, if it was merged before
+			err = tx.Model(&PostitOrigin{}).
+				Where("postit_id = ?", pid).
+				Update("postit_id", target).
+				Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&Comment{}).
+				Where("postit_id = ?", pid).
+				Update("postit_id", target).
+				Error
+			if err != nil {
+				return err
+			}
+			err = tx.Create(&PostitOrigin{
+				PostitID:   target,
+				OriginalID: p.ID,
+				Content:    p.Content,
+				UserID:     p.UserID,
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			err = moveVotes(tx, pid, target)
+			if err != nil {
+				return err
+			}
+
+			err = tx.Delete(&Postit{}, pid).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if content == "" {
+			return nil
+		}
+		return tx.Model(&Postit{}).Where("id = ?", target).Update("content", content).Error
+	})
+}
+
+// moveVotes gives the votes on a post-it to another one, adding them to the
+// votes of the same users.
+func moveVotes(tx *gorm.DB, from int64, to int64) error {
+	var votes []Vote
+	err := tx.Where("postit_id = ?", from).Find(&votes).Error
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, v := range votes {
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "postit_id"}, {Name: "group_id"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"count":      gorm.Expr("count + ?", v.Count),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&Vote{UserID: v.UserID, PostitID: to, Count: v.Count}).Error
 		if err != nil {
 			return err
 		}
+		total += v.Count
+	}
+
+	err = tx.Where("postit_id = ?", from).Delete(&Vote{}).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&Postit{}).
+		Where("id = ?", to).
+		Update("votes", gorm.Expr("votes + ?", total)).
+		Error
+}
+
+// VoteLimits are checked while voting. 0 for no limit.
+type VoteLimits struct {
+	// for the user in the retro
+	Budget int
+	// for the user on the post-it or the group
+	PerPostit int
+}
+
+// VotePostitByID adds a vote of the user on the post-it of the retro. The
+// counts are incremented in place, so that concurrent votes are not lost.
+func (repo *GORMRetroRepository) VotePostitByID(
+	ctx context.Context,
+	rid int64,
+	pid int64,
+	uid int64,
+	limits VoteLimits,
+) error {
+	return repo.vote(ctx, rid, Vote{UserID: uid, PostitID: pid}, &Postit{ID: pid}, limits)
+}
+
+// UnvotePostitByID takes back a vote of the user on the post-it, in place as
+// well.
+func (repo *GORMRetroRepository) UnvotePostitByID(ctx context.Context, pid int64, uid int64) error {
+	return repo.unvote(ctx, Vote{UserID: uid, PostitID: pid}, &Postit{ID: pid})
+}
+
+// vote adds the vote and increments the votes of the post-it or the group.
+func (repo *GORMRetroRepository) vote(
+	ctx context.Context,
+	rid int64,
+	v Vote,
+	target any,
+	limits VoteLimits,
+) error {
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if limits.Budget > 0 || limits.PerPostit > 0 {
+			err := checkVoteLimits(tx, rid, v, limits)
+			if err != nil {
+				return err
+			}
+		}
 
-		p.Votes++
-		_, err = dao.UpdatePostit(ctx, p)
+		// INSERT ... ON DUPLICATE KEY UPDATE count = count + 1
+		v.Count = 1
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "postit_id"}, {Name: "group_id"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"count":      gorm.Expr("count + 1"),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&v).Error
 		if err != nil {
 			return err
 		}
+
+		res := tx.Model(target).Update("votes", gorm.Expr("votes + 1"))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrIDNotFound
+		}
 		return nil
 	})
 	return err
 }
 
+// checkVoteLimits locks the user until the end of the transaction, so that
+// the votes of the user are counted one at a time. The votes are counted with
+// locking reads as well, which see the votes committed while waiting for the
+// lock whatever the snapshot of the transaction.
+func checkVoteLimits(tx *gorm.DB, rid int64, v Vote, limits VoteLimits) error {
+	var u User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", v.UserID).First(&u).Error
+	if err != nil {
+		return err
+	}
+
+	if limits.Budget > 0 {
+		var used int64
+		err = tx.Model(&Vote{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("COALESCE(SUM(count), 0)").
+			Scopes(votesInRetro(rid)).
+			Where("user_id = ?", v.UserID).
+			Scan(&used).Error
+		if err != nil {
+			return err
+		}
+		if used >= int64(limits.Budget) {
+			return ErrNoVotesLeft
+		}
+	}
+
+	if limits.PerPostit > 0 {
+		var count int64
+		err = tx.Model(&Vote{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("COALESCE(SUM(count), 0)").
+			Where("user_id = ? AND postit_id = ? AND group_id = ?", v.UserID, v.PostitID, v.GroupID).
+			Scan(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= int64(limits.PerPostit) {
+			return ErrPostitVoteLimit
+		}
+	}
+	return nil
+}
+
+// unvote takes back the vote and decrements the votes of the post-it or the
+// group.
+func (repo *GORMRetroRepository) unvote(ctx context.Context, v Vote, target any) error {
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Vote{}).
+			Where(
+				"user_id = ? AND postit_id = ? AND group_id = ? AND count > 0",
+				v.UserID,
+				v.PostitID,
+				v.GroupID,
+			).
+			Update("count", gorm.Expr("count - 1"))
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNoVote
+		}
+
+		err := tx.Where(
+			"user_id = ? AND postit_id = ? AND group_id = ? AND count = 0",
+			v.UserID,
+			v.PostitID,
+			v.GroupID,
+		).
+			Delete(&Vote{}).
+			Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(target).
+			Where("votes > 0").
+			Update("votes", gorm.Expr("votes - 1")).
+			Error
+	})
+	return err
+}
+
+// votesInRetro selects the votes on the post-its and on the groups of the
+// retro.
+func votesInRetro(rid int64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		sub := db.Session(&gorm.Session{NewDB: true})
+		questions := sub.Model(&Question{}).Select("id").Where("retro_id = ?", rid)
+		return db.Where(
+			"(votes.postit_id IN (?) OR votes.group_id IN (?))",
+			sub.Model(&Postit{}).Select("id").Where("question_id IN (?)", questions),
+			sub.Model(&PostitGroup{}).Select("id").Where("question_id IN (?)", questions),
+		)
+	}
+}
+
+// GetUserVotes returns the votes of the user in the retro.
+func (repo *GORMRetroRepository) GetUserVotes(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+) ([]Vote, error) {
+	var votes []Vote
+	err := repo.db.WithContext(ctx).
+		Scopes(votesInRetro(rid)).
+		Where("user_id = ?", uid).
+		Find(&votes).Error
+	return votes, err
+}
+
+// GetRetroVotes returns the votes of all the users in the retro.
+func (repo *GORMRetroRepository) GetRetroVotes(ctx context.Context, rid int64) ([]Vote, error) {
+	var votes []Vote
+	err := repo.db.WithContext(ctx).
+		Scopes(votesInRetro(rid)).
+		Find(&votes).Error
+	return votes, err
+}
+
 func (repo *GORMRetroRepository) GetTopVotePostits(
 	ctx context.Context,
 	rid int64,
@@ -286,3 +1112,164 @@ func (repo *GORMRetroRepository) GetTopVotePostits(
 }
 
 // }}}
+// {{{ Group
+
+// CreateGroup creates the group with the post-its, in this order. The
+// post-its must belong to the question of the group.
+func (repo *GORMRetroRepository) CreateGroup(
+	ctx context.Context,
+	g PostitGroup,
+	pids []int64,
+) (PostitGroup, error) {
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit("Postits").Create(&g).Error
+		if err != nil {
+			return err
+		}
+
+		for i, pid := range pids {
+			res := tx.Model(&Postit{}).
+				Where("id = ? AND question_id = ?", pid, g.QuestionID).
+				Updates(map[string]any{"group_id": g.ID, "group_position": i})
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return ErrIDNotFound
+			}
+		}
+		return nil
+	})
+	return g, err
+}
+
+func (repo *GORMRetroRepository) GetGroupByID(ctx context.Context, gid int64) (PostitGroup, error) {
+	var g PostitGroup
+	err := repo.db.WithContext(ctx).
+		Preload("Postits", func(db *gorm.DB) *gorm.DB {
+			return db.Order("group_position ASC")
+		}).
+		Preload("Postits.User").
+		Preload("Postits.Origins.User").
+		Where("id = ?", gid).
+		First(&g).
+		Error
+	return g, err
+}
+
+func (repo *GORMRetroRepository) RenameGroup(ctx context.Context, gid int64, title string) error {
+	return repo.db.WithContext(ctx).
+		Model(&PostitGroup{}).
+		Where("id = ?", gid).
+		Update("title", title).
+		Error
+}
+
+// AddPostitToGroup puts the post-it at the end of the group, leaving its
+// previous group if any.
+func (repo *GORMRetroRepository) AddPostitToGroup(ctx context.Context, gid int64, pid int64) error {
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var last int
+		err := tx.Model(&Postit{}).
+			Select("COALESCE(MAX(group_position), -1)").
+			Where("group_id = ?", gid).
+			Scan(&last).
+			Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&Postit{}).
+			Where("id = ?", pid).
+			Updates(map[string]any{"group_id": gid, "group_position": last + 1}).
+			Error
+	})
+}
+
+func (repo *GORMRetroRepository) RemovePostitFromGroup(ctx context.Context, pid int64) error {
+	return repo.db.WithContext(ctx).
+		Model(&Postit{}).
+		Where("id = ?", pid).
+		Updates(map[string]any{"group_id": nil, "group_position": 0}).
+		Error
+}
+
+// DeleteGroupByID dissolves the group. Its post-its are kept, the votes on the
+// group are given back.
+func (repo *GORMRetroRepository) DeleteGroupByID(ctx context.Context, gid int64) error {
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Postit{}).
+			Where("group_id = ?", gid).
+			Updates(map[string]any{"group_id": nil, "group_position": 0}).
+			Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("group_id = ?", gid).Delete(&Vote{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&PostitGroup{}, gid).Error
+	})
+}
+
+// VoteGroupByID adds a vote of the user on the group as a whole.
+func (repo *GORMRetroRepository) VoteGroupByID(
+	ctx context.Context,
+	rid int64,
+	gid int64,
+	uid int64,
+	limits VoteLimits,
+) error {
+	return repo.vote(ctx, rid, Vote{UserID: uid, GroupID: gid}, &PostitGroup{ID: gid}, limits)
+}
+
+func (repo *GORMRetroRepository) UnvoteGroupByID(ctx context.Context, gid int64, uid int64) error {
+	return repo.unvote(ctx, Vote{UserID: uid, GroupID: gid}, &PostitGroup{ID: gid})
+}
+
+// }}}
+// {{{ Comment
+
+func (repo *GORMRetroRepository) CreateComment(ctx context.Context, c Comment) (Comment, error) {
+	err := repo.db.WithContext(ctx).Create(&c).Error
+	return c, err
+}
+
+func (repo *GORMRetroRepository) GetCommentByID(ctx context.Context, cid int64) (Comment, error) {
+	var c Comment
+	err := repo.db.WithContext(ctx).
+		Preload("User").
+		Where("id = ?", cid).
+		First(&c).
+		Error
+	return c, err
+}
+
+// GetCommentsByPostitID returns the comments on the post-it, the oldest first.
+func (repo *GORMRetroRepository) GetCommentsByPostitID(
+	ctx context.Context,
+	pid int64,
+) ([]Comment, error) {
+	var comments []Comment
+	err := repo.db.WithContext(ctx).
+		Preload("User").
+		Where("postit_id = ?", pid).
+		Order("created_at ASC").
+		Find(&comments).
+		Error
+	return comments, err
+}
+
+func (repo *GORMRetroRepository) UpdateComment(ctx context.Context, c Comment) (Comment, error) {
+	err := repo.db.WithContext(ctx).Omit(clause.Associations).Save(&c).Error
+	return c, err
+}
+
+func (repo *GORMRetroRepository) DeleteCommentByID(ctx context.Context, cid int64) error {
+	return repo.db.WithContext(ctx).Delete(&Comment{}, cid).Error
+}
+
+// }}}
@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// the database of the docker compose, QOOLDOWN_TEST_DSN to use another one
+const testDSN = "root:root@tcp(localhost:3336)/qooldown_test?parseTime=True"
+
+// testDB connects to the test database. The test is skipped without one,
+// unless QOOLDOWN_TEST_DSN asks for it (make testdb).
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn, required := os.LookupEnv("QOOLDOWN_TEST_DSN")
+	if !required {
+		dsn = testDSN
+	}
+
+	// the connection is checked when opening
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil && required {
+		t.Fatalf("test database: %v", err)
+	}
+	if err != nil {
+		t.Skipf("no test database, run make testdb: %v", err)
+	}
+
+	err = InitTable(db)
+	if err != nil {
+		t.Fatalf("init tables: %v", err)
+	}
+	return db
+}
+
+// newTestPostit creates the users, and a retro of the first one with a
+// post-it to vote for.
+func newTestPostit(t *testing.T, repo RetroRepository, db *gorm.DB, users int) ([]User, Retro, Postit) {
+	t.Helper()
+	ctx := context.Background()
+
+	// the tables are kept between the runs
+	prefix := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+	u := make([]User, users)
+	for i := range u {
+		u[i] = User{Username: fmt.Sprintf("%s-%d", prefix, i)}
+		err := db.Create(&u[i]).Error
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+
+	tmpl, err := repo.InsertTemplate(ctx, Template{
+		Name:      prefix,
+		UserID:    u[0].ID,
+		Questions: []TemplateQuestion{{Content: "What went well?"}},
+	})
+	if err != nil {
+		t.Fatalf("insert template: %v", err)
+	}
+	retro, err := repo.CreateRetro(ctx, tmpl.ID, Retro{Name: prefix, UserID: u[0].ID})
+	if err != nil {
+		t.Fatalf("create retro: %v", err)
+	}
+	p, err := repo.CreatePostit(ctx, Postit{
+		UserID:     u[0].ID,
+		QuestionID: retro.Questions[0].ID,
+		Content:    "CI is slow",
+	})
+	if err != nil {
+		t.Fatalf("create post-it: %v", err)
+	}
+	return u, retro, p
+}
+
+// voteConcurrently votes n times for the post-it, at the same time, cycling
+// over the users. It returns the votes counted and the ones refused for lack
+// of budget.
+func voteConcurrently(
+	t *testing.T,
+	repo RetroRepository,
+	rid int64,
+	pid int64,
+	users []User,
+	n int,
+	limits VoteLimits,
+) (int, int) {
+	t.Helper()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		counted  int
+		refused  int
+		start    = make(chan struct{})
+		failures []error
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := repo.VotePostitByID(context.Background(), rid, pid, users[i%len(users)].ID, limits)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				counted++
+			case errors.Is(err, ErrNoVotesLeft):
+				refused++
+			default:
+				failures = append(failures, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for _, err := range failures {
+		t.Errorf("vote: %v", err)
+	}
+	return counted, refused
+}
+
+// checkVotes checks that the votes of the post-it are the sum of the vote
+// rows.
+func checkVotes(t *testing.T, db *gorm.DB, pid int64, want int) {
+	t.Helper()
+	var p Postit
+	err := db.First(&p, pid).Error
+	if err != nil {
+		t.Fatalf("get post-it: %v", err)
+	}
+	var sum int
+	err = db.Model(&Vote{}).Select("COALESCE(SUM(count), 0)").Where("postit_id = ?", pid).Scan(&sum).Error
+	if err != nil {
+		t.Fatalf("sum votes: %v", err)
+	}
+
+	if p.Votes != sum {
+		t.Fatalf("post-it has %d votes, the vote rows %d", p.Votes, sum)
+	}
+	if p.Votes != want {
+		t.Fatalf("got %d votes, want %d", p.Votes, want)
+	}
+}
+
+func TestVotePostitConcurrently(t *testing.T) {
+	db := testDB(t)
+	repo := NewRetroRepository(db)
+
+	const votes = 300
+	// several votes of each user at the same time, on the same vote row
+	users, retro, p := newTestPostit(t, repo, db, 20)
+
+	counted, refused := voteConcurrently(t, repo, retro.ID, p.ID, users, votes, VoteLimits{})
+	if counted != votes || refused != 0 {
+		t.Fatalf("got %d votes counted and %d refused, want %d counted", counted, refused, votes)
+	}
+	checkVotes(t, db, p.ID, votes)
+}
+
+func TestVotePostitBudgetConcurrently(t *testing.T) {
+	db := testDB(t)
+	repo := NewRetroRepository(db)
+
+	const (
+		votes  = 300
+		budget = 3
+	)
+	users, retro, p := newTestPostit(t, repo, db, 10)
+
+	counted, refused := voteConcurrently(t, repo, retro.ID, p.ID, users, votes, VoteLimits{Budget: budget})
+	if counted != budget*len(users) || refused != votes-counted {
+		t.Fatalf("got %d votes counted and %d refused, want %d counted", counted, refused, budget*len(users))
+	}
+	checkVotes(t, db, p.ID, budget*len(users))
+
+	// never more than the budget for anyone
+	for _, u := range users {
+		var used int
+		err := db.Model(&Vote{}).
+			Select("COALESCE(SUM(count), 0)").
+			Where("user_id = ? AND postit_id = ?", u.ID, p.ID).
+			Scan(&used).Error
+		if err != nil {
+			t.Fatalf("sum votes: %v", err)
+		}
+		if used != budget {
+			t.Fatalf("user %d has %d votes, want the budget %d", u.ID, used, budget)
+		}
+	}
+}
+
+func TestGetRetroByIDNestsGroupedPostits(t *testing.T) {
+	db := testDB(t)
+	repo := NewRetroRepository(db)
+	ctx := context.Background()
+
+	users, retro, alone := newTestPostit(t, repo, db, 1)
+	qid := retro.Questions[0].ID
+	var grouped []int64
+	for range 2 {
+		p, err := repo.CreatePostit(ctx, Postit{UserID: users[0].ID, QuestionID: qid, Content: "CI is slow"})
+		if err != nil {
+			t.Fatalf("create post-it: %v", err)
+		}
+		grouped = append(grouped, p.ID)
+	}
+	g, err := repo.CreateGroup(ctx, PostitGroup{QuestionID: qid, Title: "CI"}, grouped)
+	if err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+
+	retro, err = repo.GetRetroByID(ctx, retro.ID)
+	if err != nil {
+		t.Fatalf("get retro: %v", err)
+	}
+	q := retro.Questions[0]
+	if len(q.Postits) != 1 || q.Postits[0].ID != alone.ID {
+		t.Fatalf("got %d post-its out of the groups, want only %d", len(q.Postits), alone.ID)
+	}
+	if len(q.Groups) != 1 || q.Groups[0].ID != g.ID || len(q.Groups[0].Postits) != 2 {
+		t.Fatalf("got groups %+v, want the group with its 2 post-its", q.Groups)
+	}
+	for i, p := range q.Groups[0].Postits {
+		if p.ID != grouped[i] {
+			t.Fatalf("got post-it %d at %d in the group, want %d", p.ID, i, grouped[i])
+		}
+	}
+}
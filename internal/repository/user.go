@@ -22,6 +22,9 @@ type User struct {
 
 	Username string `gorm:"unique" json:"username"`
 	Password string `              json:"-"`
+
+	// joined a single retro with a share link, cannot log in
+	Guest bool `json:"guest"`
 }
 
 type UserRepository interface {
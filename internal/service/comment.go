@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/chenmuyao/qooldown/internal/repository"
+)
+
+// GetRetroWithComments returns the retro as GetRetroByID does, with the
+// comments on its post-its.
+func (r *retroService) GetRetroWithComments(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+) (repository.Retro, error) {
+	retro, err := r.GetRetroByID(ctx, rid, uid)
+	if err != nil {
+		return repository.Retro{}, err
+	}
+
+	comments, err := r.repo.GetRetroComments(ctx, rid)
+	if err != nil {
+		return repository.Retro{}, err
+	}
+	byPostit := make(map[int64][]repository.Comment)
+	for _, c := range comments {
+		byPostit[c.PostitID] = append(byPostit[c.PostitID], c)
+	}
+
+	for i := range retro.Questions {
+		q := &retro.Questions[i]
+		for j := range q.Postits {
+			q.Postits[j].Comments = byPostit[q.Postits[j].ID]
+		}
+		for j := range q.Groups {
+			g := &q.Groups[j]
+			for k := range g.Postits {
+				g.Postits[k].Comments = byPostit[g.Postits[k].ID]
+			}
+		}
+	}
+	return retro, nil
+}
+
+// GetComments returns the discussion on the post-it, the oldest first.
+func (r *retroService) GetComments(
+	ctx context.Context,
+	pid int64,
+	uid int64,
+) ([]repository.Comment, error) {
+	_, retro, err := r.postitRetro(ctx, pid)
+	if err != nil {
+		return nil, err
+	}
+	err = r.checkMember(ctx, retro, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.repo.GetCommentsByPostitID(ctx, pid)
+}
+
+// CreateComment adds a comment of the user on the post-it, until the retro is
+// closed.
+func (r *retroService) CreateComment(
+	ctx context.Context,
+	pid int64,
+	body string,
+	uid int64,
+) (repository.Comment, error) {
+	p, retro, err := r.postitRetro(ctx, pid)
+	if err != nil {
+		return repository.Comment{}, err
+	}
+	err = r.checkRole(ctx, retro, uid, writers)
+	if err != nil {
+		return repository.Comment{}, err
+	}
+	if retro.Phase == repository.PhaseClosed {
+		return repository.Comment{}, ErrWrongPhase
+	}
+
+	c, err := r.repo.CreateComment(ctx, repository.Comment{
+		PostitID: pid,
+		UserID:   uid,
+		Body:     body,
+	})
+	if err != nil {
+		return repository.Comment{}, err
+	}
+
+	// get the owner as well
+	c, err = r.repo.GetCommentByID(ctx, c.ID)
+	if err != nil {
+		return repository.Comment{}, err
+	}
+
+	r.publishComment(ctx, EventCommentCreated, retro, p, c)
+	return c, nil
+}
+
+func (r *retroService) UpdateComment(
+	ctx context.Context,
+	pid int64,
+	cid int64,
+	body string,
+	uid int64,
+) (repository.Comment, error) {
+	p, retro, c, err := r.postitComment(ctx, pid, cid, uid)
+	if err != nil {
+		return repository.Comment{}, err
+	}
+
+	now := time.Now()
+	c.Body = body
+	c.EditedAt = &now
+
+	c, err = r.repo.UpdateComment(ctx, c)
+	if err != nil {
+		return repository.Comment{}, err
+	}
+
+	r.publishComment(ctx, EventCommentUpdated, retro, p, c)
+	return c, nil
+}
+
+func (r *retroService) DeleteCommentByID(ctx context.Context, pid int64, cid int64, uid int64) error {
+	p, retro, c, err := r.postitComment(ctx, pid, cid, uid)
+	if err != nil {
+		return err
+	}
+
+	err = r.repo.DeleteCommentByID(ctx, cid)
+	if err != nil {
+		return err
+	}
+
+	r.publishComment(ctx, EventCommentDeleted, retro, p, repository.Comment{
+		ID:       c.ID,
+		PostitID: c.PostitID,
+	})
+	return nil
+}
+
+// postitRetro returns the post-it and its retro.
+func (r *retroService) postitRetro(
+	ctx context.Context,
+	pid int64,
+) (repository.Postit, repository.Retro, error) {
+	p, err := r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return repository.Postit{}, repository.Retro{}, err
+	}
+
+	retro, err := r.repo.GetRetroByQuestionID(ctx, p.QuestionID)
+	if err != nil {
+		return repository.Postit{}, repository.Retro{}, err
+	}
+	return p, retro, nil
+}
+
+// postitComment returns the comment of the post-it, with the post-it and its
+// retro, if the user may change it: only the author does, while still a
+// writer of the retro and until the retro is closed.
+func (r *retroService) postitComment(
+	ctx context.Context,
+	pid int64,
+	cid int64,
+	uid int64,
+) (repository.Postit, repository.Retro, repository.Comment, error) {
+	c, err := r.repo.GetCommentByID(ctx, cid)
+	if err != nil {
+		return repository.Postit{}, repository.Retro{}, repository.Comment{}, err
+	}
+	if c.PostitID != pid {
+		return repository.Postit{}, repository.Retro{}, repository.Comment{}, ErrIDNotFound
+	}
+	// compare the owner
+	if c.UserID != uid {
+		return repository.Postit{}, repository.Retro{}, repository.Comment{}, ErrNoAccess
+	}
+
+	p, retro, err := r.postitRetro(ctx, pid)
+	if err != nil {
+		return repository.Postit{}, repository.Retro{}, repository.Comment{}, err
+	}
+	// observers only read, their own comments as well
+	err = r.checkRole(ctx, retro, uid, writers)
+	if err != nil {
+		return repository.Postit{}, repository.Retro{}, repository.Comment{}, err
+	}
+	if retro.Phase == repository.PhaseClosed {
+		return repository.Postit{}, repository.Retro{}, repository.Comment{}, ErrWrongPhase
+	}
+	return p, retro, c, nil
+}
+
+func (r *retroService) publishComment(
+	ctx context.Context,
+	typ EventType,
+	retro repository.Retro,
+	p repository.Postit,
+	c repository.Comment,
+) {
+	r.publisher.Publish(ctx, Event{
+		Type:       typ,
+		RetroID:    retro.ID,
+		QuestionID: p.QuestionID,
+		Comment:    &c,
+	})
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+
+	"github.com/chenmuyao/qooldown/internal/repository"
+)
+
+type EventType string
+
+const (
+	EventPostitCreated  EventType = "postit_created"
+	EventPostitUpdated  EventType = "postit_updated"
+	EventPostitDeleted  EventType = "postit_deleted"
+	EventPostitVoted    EventType = "postit_voted"
+	EventRetroDeleted   EventType = "retro_deleted"
+	EventPhaseChanged   EventType = "phase_changed"
+	EventRetroRevealed  EventType = "retro_revealed"
+	EventTimerChanged   EventType = "timer_changed"
+	EventMembersChanged EventType = "members_changed"
+	EventGroupCreated   EventType = "group_created"
+	EventGroupUpdated   EventType = "group_updated"
+	EventGroupDeleted   EventType = "group_deleted"
+	EventGroupVoted     EventType = "group_voted"
+	EventPostitsMerged  EventType = "postits_merged"
+	EventPostitMoved    EventType = "postit_moved"
+	EventCommentCreated EventType = "comment_created"
+	EventCommentUpdated EventType = "comment_updated"
+	EventCommentDeleted EventType = "comment_deleted"
+)
+
+// Event is a change on a retro board, published once the change is saved.
+type Event struct {
+	Type       EventType               `json:"type"`
+	RetroID    int64                   `json:"retro_id"`
+	QuestionID int64                   `json:"question_id"`
+	Postit     *repository.Postit      `json:"postit"`
+	Postits    []repository.Postit     `json:"postits"`
+	Group      *repository.PostitGroup `json:"group"`
+	// the post-its merged into Postit, deleted
+	MergedIDs []int64                `json:"merged_ids"`
+	Phase     string                 `json:"phase"`
+	Timer     *TimerState            `json:"timer"`
+	Members   []repository.RetroUser `json:"members"`
+	Comment   *repository.Comment    `json:"comment"`
+
+	// the retro hides the content of the others until it is revealed
+	ContentHidden bool `json:"content_hidden"`
+
+	// the retro hides the votes of the others until the vote is closed, the
+	// votes of each user are given by post-it or group ID, then user ID
+	VotesHidden    bool                    `json:"votes_hidden"`
+	UserVotes      map[int64]map[int64]int `json:"user_votes"`
+	UserGroupVotes map[int64]map[int64]int `json:"user_group_votes"`
+}
+
+// EventPublisher pushes the events to the clients following the retro.
+type EventPublisher interface {
+	Publish(ctx context.Context, event Event)
+}
+
+// ViewFor returns the event as the user is allowed to see it.
+func (e Event) ViewFor(uid int64) Event {
+	if e.Postit != nil {
+		p := *e.Postit
+		e.maskPostit(&p, uid)
+		e.Postit = &p
+	}
+	if e.Postits != nil {
+		postits := make([]repository.Postit, len(e.Postits))
+		copy(postits, e.Postits)
+		for i := range postits {
+			e.maskPostit(&postits[i], uid)
+		}
+		e.Postits = postits
+	}
+	if e.Group != nil {
+		g := *e.Group
+		g.Postits = make([]repository.Postit, len(e.Group.Postits))
+		copy(g.Postits, e.Group.Postits)
+		for i := range g.Postits {
+			e.maskPostit(&g.Postits[i], uid)
+		}
+		if e.VotesHidden {
+			g.Votes = e.UserGroupVotes[g.ID][uid]
+		}
+		g.TotalVotes = totalVotes(g)
+		e.Group = &g
+	}
+	// only for the server
+	e.UserVotes = nil
+	e.UserGroupVotes = nil
+	return e
+}
+
+func (e Event) maskPostit(p *repository.Postit, uid int64) {
+	maskPostit(p, uid, e.ContentHidden)
+	if e.VotesHidden {
+		p.Votes = e.UserVotes[p.ID][uid]
+	}
+}
+
+// userVotes indexes the votes by post-it ID or group ID, then user ID.
+func userVotes(votes []repository.Vote) (map[int64]map[int64]int, map[int64]map[int64]int) {
+	postits := make(map[int64]map[int64]int)
+	groups := make(map[int64]map[int64]int)
+	for _, v := range votes {
+		res, id := postits, v.PostitID
+		if v.GroupID != 0 {
+			res, id = groups, v.GroupID
+		}
+		if res[id] == nil {
+			res[id] = make(map[int64]int)
+		}
+		res[id][v.UserID] = v.Count
+	}
+	return postits, groups
+}
+
+// contentHidden tells if the retro hides the content of the others, whatever
+// the visibility of the post-its.
+func contentHidden(retro repository.Retro) bool {
+	return retro.HiddenUntilReveal && !retro.Revealed
+}
+
+// votesHidden tells if the retro hides the votes of the others, until the
+// vote phase is over.
+func votesHidden(retro repository.Retro) bool {
+	return retro.HideVotesDuringVote && phaseIndex(retro.Phase) <= phaseIndex(repository.PhaseVote)
+}
+
+// maskPostit hides the content if the post is not visible, or if all the posts
+// are hidden, and it does not belong to the user. The same goes for the
+// post-its merged into it.
+func maskPostit(p *repository.Postit, uid int64, hideAll bool) {
+	if !hideAll && p.IsVisible {
+		return
+	}
+	if p.UserID != uid {
+		p.Content = NoContentPlaceholder
+	}
+
+	if len(p.Origins) == 0 {
+		return
+	}
+	// shared with the views of the others
+	origins := make([]repository.PostitOrigin, len(p.Origins))
+	copy(origins, p.Origins)
+	for i := range origins {
+		if origins[i].UserID != uid {
+			origins[i].Content = NoContentPlaceholder
+		}
+	}
+	p.Origins = origins
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/chenmuyao/qooldown/internal/repository"
+)
+
+var (
+	ErrWrongQuestion = errors.New("post-it not in the question of the group")
+	ErrNotInGroup    = errors.New("post-it not in the group")
+)
+
+type GroupCreate struct {
+	QuestionID int64   `json:"question_id" binding:"required"`
+	Title      string  `json:"title"`
+	PostitIDs  []int64 `json:"postit_ids"`
+}
+
+// CreateGroup groups the post-its of a question, in this order.
+func (r *retroService) CreateGroup(
+	ctx context.Context,
+	group GroupCreate,
+	uid int64,
+) (repository.PostitGroup, error) {
+	retro, err := r.repo.GetRetroByQuestionID(ctx, group.QuestionID)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+	err = r.checkGrouping(ctx, retro, uid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	// the post-its may leave other groups
+	var previous []int64
+	for _, pid := range group.PostitIDs {
+		p, err := r.repo.GetPostitByID(ctx, pid)
+		if err != nil {
+			return repository.PostitGroup{}, err
+		}
+		if p.QuestionID != group.QuestionID {
+			return repository.PostitGroup{}, ErrWrongQuestion
+		}
+		if p.GroupID != nil && !slices.Contains(previous, *p.GroupID) {
+			previous = append(previous, *p.GroupID)
+		}
+	}
+
+	g, err := r.repo.CreateGroup(ctx, repository.PostitGroup{
+		QuestionID: group.QuestionID,
+		Title:      group.Title,
+	}, group.PostitIDs)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	g, err = r.publishGroup(ctx, EventGroupCreated, retro, g.ID, uid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+	for _, gid := range previous {
+		_, err = r.publishGroup(ctx, EventGroupUpdated, retro, gid, uid)
+		if err != nil {
+			return repository.PostitGroup{}, err
+		}
+	}
+	return g, nil
+}
+
+func (r *retroService) RenameGroup(
+	ctx context.Context,
+	gid int64,
+	title string,
+	uid int64,
+) (repository.PostitGroup, error) {
+	_, retro, err := r.groupRetro(ctx, gid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+	err = r.checkGrouping(ctx, retro, uid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	err = r.repo.RenameGroup(ctx, gid, title)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	return r.publishGroup(ctx, EventGroupUpdated, retro, gid, uid)
+}
+
+// DissolveGroup deletes the group and keeps its post-its.
+func (r *retroService) DissolveGroup(ctx context.Context, gid int64, uid int64) error {
+	g, retro, err := r.groupRetro(ctx, gid)
+	if err != nil {
+		return err
+	}
+	err = r.checkGrouping(ctx, retro, uid)
+	if err != nil {
+		return err
+	}
+
+	err = r.repo.DeleteGroupByID(ctx, gid)
+	if err != nil {
+		return err
+	}
+
+	r.publisher.Publish(ctx, Event{
+		Type:       EventGroupDeleted,
+		RetroID:    retro.ID,
+		QuestionID: g.QuestionID,
+		Group:      &repository.PostitGroup{ID: g.ID, QuestionID: g.QuestionID},
+	})
+	return nil
+}
+
+// AddPostitToGroup moves the post-it at the end of the group, from its
+// previous group if any.
+func (r *retroService) AddPostitToGroup(
+	ctx context.Context,
+	gid int64,
+	pid int64,
+	uid int64,
+) (repository.PostitGroup, error) {
+	g, retro, err := r.groupRetro(ctx, gid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+	err = r.checkGrouping(ctx, retro, uid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	p, err := r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+	if p.QuestionID != g.QuestionID {
+		return repository.PostitGroup{}, ErrWrongQuestion
+	}
+
+	err = r.repo.AddPostitToGroup(ctx, gid, pid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	if p.GroupID != nil && *p.GroupID != gid {
+		_, err = r.publishGroup(ctx, EventGroupUpdated, retro, *p.GroupID, uid)
+		if err != nil {
+			return repository.PostitGroup{}, err
+		}
+	}
+	return r.publishGroup(ctx, EventGroupUpdated, retro, gid, uid)
+}
+
+// RemovePostitFromGroup takes the post-it out of the group, back alone in
+// its question.
+func (r *retroService) RemovePostitFromGroup(
+	ctx context.Context,
+	gid int64,
+	pid int64,
+	uid int64,
+) (repository.PostitGroup, error) {
+	_, retro, err := r.groupRetro(ctx, gid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+	err = r.checkGrouping(ctx, retro, uid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	p, err := r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+	if p.GroupID == nil || *p.GroupID != gid {
+		return repository.PostitGroup{}, ErrNotInGroup
+	}
+
+	err = r.repo.RemovePostitFromGroup(ctx, pid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	return r.publishGroup(ctx, EventGroupUpdated, retro, gid, uid)
+}
+
+// VoteGroupByID votes for the group as a whole. It is a self vote if the
+// group has a post-it of the user.
+func (r *retroService) VoteGroupByID(ctx context.Context, gid int64, uid int64) error {
+	g, retro, err := r.groupRetro(ctx, gid)
+	if err != nil {
+		return err
+	}
+	err = r.checkRole(ctx, retro, uid, writers)
+	if err != nil {
+		return err
+	}
+	if retro.Phase != repository.PhaseVote {
+		return ErrWrongPhase
+	}
+
+	if !selfVoteAllowed(retro) {
+		for _, p := range g.Postits {
+			if p.UserID == uid {
+				return ErrSelfVote
+			}
+		}
+	}
+
+	err = r.repo.VoteGroupByID(ctx, retro.ID, gid, uid, repository.VoteLimits{
+		Budget:    retro.VotesPerUser,
+		PerPostit: retro.MaxVotesPerPostit,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.publishGroup(ctx, EventGroupVoted, retro, gid, uid)
+	return err
+}
+
+// UnvoteGroupByID takes back one vote of the user on the group.
+func (r *retroService) UnvoteGroupByID(ctx context.Context, gid int64, uid int64) error {
+	_, retro, err := r.groupRetro(ctx, gid)
+	if err != nil {
+		return err
+	}
+	err = r.checkRole(ctx, retro, uid, writers)
+	if err != nil {
+		return err
+	}
+	if retro.Phase != repository.PhaseVote {
+		return ErrWrongPhase
+	}
+
+	err = r.repo.UnvoteGroupByID(ctx, gid, uid)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.publishGroup(ctx, EventGroupVoted, retro, gid, uid)
+	return err
+}
+
+// groupRetro returns the group and its retro.
+func (r *retroService) groupRetro(
+	ctx context.Context,
+	gid int64,
+) (repository.PostitGroup, repository.Retro, error) {
+	g, err := r.repo.GetGroupByID(ctx, gid)
+	if err != nil {
+		return repository.PostitGroup{}, repository.Retro{}, err
+	}
+
+	retro, err := r.repo.GetRetroByQuestionID(ctx, g.QuestionID)
+	if err != nil {
+		return repository.PostitGroup{}, repository.Retro{}, err
+	}
+	return g, retro, nil
+}
+
+// checkGrouping tells if the user may change the groups now.
+func (r *retroService) checkGrouping(ctx context.Context, retro repository.Retro, uid int64) error {
+	err := r.checkRole(ctx, retro, uid, writers)
+	if err != nil {
+		return err
+	}
+	if retro.Phase != repository.PhaseGroup {
+		return ErrWrongPhase
+	}
+	return nil
+}
+
+// publishGroup sends the group as it is saved, and returns it as the user
+// sees it.
+func (r *retroService) publishGroup(
+	ctx context.Context,
+	typ EventType,
+	retro repository.Retro,
+	gid int64,
+	uid int64,
+) (repository.PostitGroup, error) {
+	g, err := r.repo.GetGroupByID(ctx, gid)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	event := Event{
+		Type:          typ,
+		RetroID:       retro.ID,
+		QuestionID:    g.QuestionID,
+		Group:         &g,
+		ContentHidden: contentHidden(retro),
+	}
+	err = r.hideVotes(ctx, retro, &event)
+	if err != nil {
+		return repository.PostitGroup{}, err
+	}
+
+	r.publisher.Publish(ctx, event)
+	return *event.ViewFor(uid).Group, nil
+}
@@ -2,7 +2,14 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/chenmuyao/qooldown/internal/repository"
 )
@@ -10,6 +17,22 @@ import (
 var (
 	ErrNoAccess          = errors.New("not the owner of this object")
 	ErrIDNotFound        = repository.ErrIDNotFound
+	ErrWrongPhase        = errors.New("not allowed in the current phase of the retro")
+	ErrInvalidPhase      = errors.New("invalid phase transition")
+	ErrNoVotesLeft       = repository.ErrNoVotesLeft
+	ErrSelfVote          = errors.New("voting for your own post-it is not allowed")
+	ErrPostitVoteLimit   = repository.ErrPostitVoteLimit
+	ErrVotesHidden       = errors.New("votes are hidden until the vote is closed")
+	ErrNoVote            = repository.ErrNoVote
+	ErrUserNotFound      = errors.New("user not found")
+	ErrNotMember         = errors.New("not a member of the retro")
+	ErrInvalidRole       = errors.New("invalid role")
+	ErrInvalidMerge      = errors.New("post-its cannot be merged")
+	ErrInvalidPosition   = repository.ErrInvalidPosition
+	ErrOtherRetro        = errors.New("question of another retro")
+	ErrInvalidTimer      = errors.New("invalid timer action")
+	ErrTimerState        = errors.New("not allowed in the current state of the timer")
+	ErrTooManyGuests     = errors.New("too many guests in the retro")
 	NoContentPlaceholder = "~~~~~~~~\n~~~~~~~~"
 )
 
@@ -19,11 +42,44 @@ type RetroService interface {
 	DeleteTemplateByID(ctx context.Context, tid int64, uid int64) error
 	GetTemplateByID(ctx context.Context, tid int64, uid int64) (repository.Template, error)
 
-	CreateRetro(ctx context.Context, tid int64, uid int64, name string) (repository.Retro, error)
-	GetRetros(ctx context.Context) ([]repository.Retro, error)
+	CreateRetro(
+		ctx context.Context,
+		tid int64,
+		uid int64,
+		name string,
+		opts RetroOptions,
+	) (repository.Retro, error)
+	GetRetros(ctx context.Context, uid int64) ([]repository.Retro, error)
 	GetRetroByID(ctx context.Context, tid int64, uid int64) (repository.Retro, error)
+	GetRetroWithComments(ctx context.Context, rid int64, uid int64) (repository.Retro, error)
 	DeleteRetroByID(ctx context.Context, tid int64, uid int64) error
-	GetTopVotePostits(ctx context.Context, rid int64, n int) ([]repository.Postit, error)
+	ChangePhase(ctx context.Context, rid int64, uid int64, phase string) error
+	RevealRetro(ctx context.Context, rid int64, uid int64) error
+	ControlTimer(
+		ctx context.Context,
+		rid int64,
+		uid int64,
+		cmd TimerCommand,
+	) (repository.Timer, error)
+	ResumeTimers(ctx context.Context) error
+	InviteUser(
+		ctx context.Context,
+		rid int64,
+		uid int64,
+		username string,
+		role string,
+	) (repository.User, error)
+	SetMemberRole(ctx context.Context, rid int64, uid int64, member int64, role string) error
+	HandOverFacilitation(ctx context.Context, rid int64, uid int64, member int64) error
+	AuthorizeInvite(ctx context.Context, rid int64, uid int64) error
+	JoinRetro(ctx context.Context, rid int64, uid int64) error
+	JoinAsGuest(ctx context.Context, rid int64, name string) (repository.User, error)
+	GetTopVotePostits(
+		ctx context.Context,
+		rid int64,
+		uid int64,
+		n int,
+	) ([]repository.Postit, error)
 
 	CreatePostit(ctx context.Context, postit PostitCreate, uid int64) (repository.Postit, error)
 	DeletePostitByID(ctx context.Context, pid int64, uid int64) error
@@ -33,16 +89,82 @@ type RetroService interface {
 		postit PostitUpdate,
 		uid int64,
 	) (repository.Postit, error)
-	VotePostitByID(ctx context.Context, pid int64) error
+	VotePostitByID(ctx context.Context, pid int64, uid int64) error
+	UnvotePostitByID(ctx context.Context, pid int64, uid int64) error
+	MergePostits(
+		ctx context.Context,
+		pid int64,
+		merge PostitMerge,
+		uid int64,
+	) (repository.Postit, error)
+	MovePostit(
+		ctx context.Context,
+		pid int64,
+		move PostitMove,
+		uid int64,
+	) (repository.Postit, error)
+	GetMyVotes(ctx context.Context, rid int64, uid int64) (MyVotes, error)
+
+	GetComments(ctx context.Context, pid int64, uid int64) ([]repository.Comment, error)
+	CreateComment(
+		ctx context.Context,
+		pid int64,
+		body string,
+		uid int64,
+	) (repository.Comment, error)
+	UpdateComment(
+		ctx context.Context,
+		pid int64,
+		cid int64,
+		body string,
+		uid int64,
+	) (repository.Comment, error)
+	DeleteCommentByID(ctx context.Context, pid int64, cid int64, uid int64) error
+
+	CreateGroup(ctx context.Context, group GroupCreate, uid int64) (repository.PostitGroup, error)
+	RenameGroup(
+		ctx context.Context,
+		gid int64,
+		title string,
+		uid int64,
+	) (repository.PostitGroup, error)
+	DissolveGroup(ctx context.Context, gid int64, uid int64) error
+	AddPostitToGroup(
+		ctx context.Context,
+		gid int64,
+		pid int64,
+		uid int64,
+	) (repository.PostitGroup, error)
+	RemovePostitFromGroup(
+		ctx context.Context,
+		gid int64,
+		pid int64,
+		uid int64,
+	) (repository.PostitGroup, error)
+	VoteGroupByID(ctx context.Context, gid int64, uid int64) error
+	UnvoteGroupByID(ctx context.Context, gid int64, uid int64) error
 }
 
 type retroService struct {
-	repo repository.RetroRepository
+	repo      repository.RetroRepository
+	userRepo  repository.UserRepository
+	publisher EventPublisher
+
+	// countdowns of the running timers, by retro ID
+	mu        sync.Mutex
+	countdown map[int64]*time.Timer
 }
 
-func NewRetroService(repo repository.RetroRepository) RetroService {
+func NewRetroService(
+	repo repository.RetroRepository,
+	userRepo repository.UserRepository,
+	publisher EventPublisher,
+) RetroService {
 	return &retroService{
-		repo: repo,
+		repo:      repo,
+		userRepo:  userRepo,
+		publisher: publisher,
+		countdown: make(map[int64]*time.Timer),
 	}
 }
 
@@ -94,17 +216,46 @@ func (r *retroService) GetTemplateByID(
 // }}}
 // {{{ Retro
 
+// RetroOptions are the settings chosen when creating a retro
+type RetroOptions struct {
+	HiddenUntilReveal bool `json:"hidden_until_reveal"`
+	// the budget of the template if 0
+	VotesPerUser int `json:"votes_per_user"`
+	// the rule of the template if not set
+	HideVotesDuringVote *bool `json:"hide_votes_during_vote"`
+}
+
 func (r *retroService) CreateRetro(
 	ctx context.Context,
 	tid int64,
 	uid int64,
 	name string,
+	opts RetroOptions,
 ) (repository.Retro, error) {
-	return r.repo.CreateRetro(ctx, tid, uid, name)
+	t, err := r.repo.GetTemplateByID(ctx, tid)
+	if err != nil {
+		return repository.Retro{}, err
+	}
+
+	// the voting rules of the template, unless they are chosen for the retro
+	rules := t.VotingRules
+	if opts.VotesPerUser > 0 {
+		rules.VotesPerUser = opts.VotesPerUser
+	}
+	if opts.HideVotesDuringVote != nil {
+		rules.HideVotesDuringVote = *opts.HideVotesDuringVote
+	}
+
+	return r.repo.CreateRetro(ctx, tid, repository.Retro{
+		Name:              name,
+		UserID:            uid,
+		HiddenUntilReveal: opts.HiddenUntilReveal,
+		VotingRules:       rules,
+	})
 }
 
-func (r *retroService) GetRetros(ctx context.Context) ([]repository.Retro, error) {
-	return r.repo.GetRetros(ctx)
+func (r *retroService) GetRetros(ctx context.Context, uid int64) ([]repository.Retro, error) {
+	return r.repo.GetRetros(ctx, uid)
 }
 
 func (r *retroService) DeleteRetroByID(ctx context.Context, tid int64, uid int64) error {
@@ -118,7 +269,17 @@ func (r *retroService) DeleteRetroByID(ctx context.Context, tid int64, uid int64
 		return ErrNoAccess
 	}
 
-	return r.repo.DeleteRetroByID(ctx, tid)
+	err = r.repo.DeleteRetroByID(ctx, tid)
+	if err != nil {
+		return err
+	}
+	r.cancelCountdown(tid)
+
+	r.publisher.Publish(ctx, Event{
+		Type:    EventRetroDeleted,
+		RetroID: tid,
+	})
+	return nil
 }
 
 func (r *retroService) GetRetroByID(
@@ -131,25 +292,447 @@ func (r *retroService) GetRetroByID(
 	if err != nil {
 		return repository.Retro{}, err
 	}
+	err = r.checkMember(ctx, retro, uid)
+	if err != nil {
+		return repository.Retro{}, err
+	}
+
+	var votes []repository.Vote
+	hideVotes := votesHidden(retro)
+	if hideVotes {
+		// only show the votes of the user
+		votes, err = r.repo.GetUserVotes(ctx, retro.ID, uid)
+		if err != nil {
+			return repository.Retro{}, err
+		}
+	}
 
+	hideAll := contentHidden(retro)
+	view := func(p *repository.Postit) {
+		maskPostit(p, uid, hideAll)
+		if hideVotes {
+			p.Votes = votesOn(votes, p.ID)
+		}
+	}
 	for i := range retro.Questions {
-		for j := range retro.Questions[i].Postits {
-			// the post is not visible and it does not belong to the user
-			if !retro.Questions[i].Postits[j].IsVisible &&
-				retro.Questions[i].Postits[j].UserID != uid {
-				retro.Questions[i].Postits[j].Content = NoContentPlaceholder
+		q := &retro.Questions[i]
+		for j := range q.Postits {
+			view(&q.Postits[j])
+		}
+		for j := range q.Groups {
+			g := &q.Groups[j]
+			for k := range g.Postits {
+				view(&g.Postits[k])
+			}
+			if hideVotes {
+				g.Votes = groupVotesOn(votes, g.ID)
 			}
+			g.TotalVotes = totalVotes(*g)
 		}
 	}
 	return retro, nil
 }
 
+// phases are the phases of a retro in order. The owner can only move to the
+// next one, or go back to the previous one.
+var phases = []string{
+	repository.PhaseWrite,
+	repository.PhaseGroup,
+	repository.PhaseVote,
+	repository.PhaseDiscuss,
+	repository.PhaseClosed,
+}
+
+func phaseIndex(phase string) int {
+	for i, p := range phases {
+		if p == phase {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *retroService) ChangePhase(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+	phase string,
+) error {
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return err
+	}
+	// the owner or the facilitator
+	err = r.checkRole(ctx, retro, uid, facilitators)
+	if err != nil {
+		return err
+	}
+
+	from, to := phaseIndex(retro.Phase), phaseIndex(phase)
+	if to < 0 || (to != from+1 && to != from-1) {
+		return ErrInvalidPhase
+	}
+
+	return r.setPhase(ctx, retro, phase)
+}
+
+// setPhase saves the phase of the retro and tells the clients.
+func (r *retroService) setPhase(ctx context.Context, retro repository.Retro, phase string) error {
+	err := r.repo.UpdateRetroPhase(ctx, retro.ID, phase)
+	if err != nil {
+		return err
+	}
+
+	event := Event{
+		Type:          EventPhaseChanged,
+		RetroID:       retro.ID,
+		Phase:         phase,
+		ContentHidden: contentHidden(retro),
+	}
+
+	wasHidden := votesHidden(retro)
+	retro.Phase = phase
+	if votesHidden(retro) != wasHidden {
+		// the votes are shown or hidden, send them all
+		event.Postits = allPostits(retro)
+		err = r.hideVotes(ctx, retro, &event)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.publisher.Publish(ctx, event)
+	return nil
+}
+
+// allPostits returns the post-its of the retro, in a group or not.
+func allPostits(retro repository.Retro) []repository.Postit {
+	var postits []repository.Postit
+	for _, q := range retro.Questions {
+		postits = append(postits, q.Postits...)
+		for _, g := range q.Groups {
+			postits = append(postits, g.Postits...)
+		}
+	}
+	return postits
+}
+
+// RevealRetro makes all the post-its of the retro visible at once.
+func (r *retroService) RevealRetro(ctx context.Context, rid int64, uid int64) error {
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return err
+	}
+	// the owner or the facilitator
+	err = r.checkRole(ctx, retro, uid, facilitators)
+	if err != nil {
+		return err
+	}
+
+	err = r.repo.RevealRetro(ctx, rid)
+	if err != nil {
+		return err
+	}
+
+	// send everything, so that the clients do not need to reload the retro
+	retro, err = r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return err
+	}
+	event := Event{
+		Type:    EventRetroRevealed,
+		RetroID: rid,
+		Postits: allPostits(retro),
+	}
+	err = r.hideVotes(ctx, retro, &event)
+	if err != nil {
+		return err
+	}
+
+	r.publisher.Publish(ctx, event)
+	return nil
+}
+
 func (r *retroService) GetTopVotePostits(
 	ctx context.Context,
 	rid int64,
+	uid int64,
 	n int,
 ) ([]repository.Postit, error) {
-	return r.repo.GetTopVotePostits(ctx, rid, n)
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return nil, err
+	}
+	err = r.checkMember(ctx, retro, uid)
+	if err != nil {
+		return nil, err
+	}
+	// the ranking would tell the votes of the others
+	if votesHidden(retro) {
+		return nil, ErrVotesHidden
+	}
+
+	postits, err := r.repo.GetTopVotePostits(ctx, rid, n)
+	if err != nil {
+		return nil, err
+	}
+
+	hideAll := contentHidden(retro)
+	for i := range postits {
+		maskPostit(&postits[i], uid, hideAll)
+	}
+	return postits, nil
+}
+
+// }}}
+// {{{ Members
+
+var (
+	// may lead the meeting
+	facilitators = []string{repository.RoleOwner, repository.RoleFacilitator}
+	// may post and vote
+	writers = []string{
+		repository.RoleOwner,
+		repository.RoleFacilitator,
+		repository.RoleParticipant,
+	}
+)
+
+// memberRole returns the role of the user in the retro, or ErrNoAccess if the
+// user is not a member. The owner always is, even for the retros created
+// before the members.
+func (r *retroService) memberRole(
+	ctx context.Context,
+	retro repository.Retro,
+	uid int64,
+) (string, error) {
+	if retro.UserID == uid {
+		return repository.RoleOwner, nil
+	}
+	m, err := r.repo.GetRetroMember(ctx, retro.ID, uid)
+	if err == repository.ErrIDNotFound {
+		return "", ErrNoAccess
+	}
+	if err != nil {
+		return "", err
+	}
+	return m.Role, nil
+}
+
+// checkMember returns ErrNoAccess if the user is not a member of the retro.
+func (r *retroService) checkMember(ctx context.Context, retro repository.Retro, uid int64) error {
+	_, err := r.memberRole(ctx, retro, uid)
+	return err
+}
+
+// checkRole returns ErrNoAccess if the user does not have one of the roles in
+// the retro.
+func (r *retroService) checkRole(
+	ctx context.Context,
+	retro repository.Retro,
+	uid int64,
+	roles []string,
+) error {
+	role, err := r.memberRole(ctx, retro, uid)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(roles, role) {
+		return ErrNoAccess
+	}
+	return nil
+}
+
+// InviteUser makes the user with the username a member of the retro.
+func (r *retroService) InviteUser(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+	username string,
+	role string,
+) (repository.User, error) {
+	if role == "" {
+		role = repository.RoleParticipant
+	}
+	if role != repository.RoleParticipant && role != repository.RoleObserver {
+		// the facilitator is handed over
+		return repository.User{}, ErrInvalidRole
+	}
+
+	err := r.AuthorizeInvite(ctx, rid, uid)
+	if err != nil {
+		return repository.User{}, err
+	}
+
+	u, err := r.userRepo.FindByUsername(ctx, username)
+	if err == repository.ErrUserNotFound {
+		return repository.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return repository.User{}, err
+	}
+
+	err = r.repo.AddRetroMember(ctx, rid, u.ID, role)
+	if err != nil {
+		return repository.User{}, err
+	}
+	return u, nil
+}
+
+// AuthorizeInvite tells if the user may invite people to the retro.
+func (r *retroService) AuthorizeInvite(ctx context.Context, rid int64, uid int64) error {
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return err
+	}
+	// compare the owner
+	if retro.UserID != uid {
+		return ErrNoAccess
+	}
+	return nil
+}
+
+// JoinRetro makes the user a member of the retro, with an invite link.
+func (r *retroService) JoinRetro(ctx context.Context, rid int64, uid int64) error {
+	_, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return err
+	}
+	return r.repo.AddRetroMember(ctx, rid, uid, repository.RoleParticipant)
+}
+
+// the guests are users kept for their post-its, a share link cannot create
+// more than this
+const maxGuests = 50
+
+// JoinAsGuest creates a guest user with the display name, member of the
+// retro only.
+func (r *retroService) JoinAsGuest(
+	ctx context.Context,
+	rid int64,
+	name string,
+) (repository.User, error) {
+	_, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return repository.User{}, err
+	}
+	n, err := r.repo.CountRetroGuests(ctx, rid)
+	if err != nil {
+		return repository.User{}, err
+	}
+	if n >= maxGuests {
+		return repository.User{}, ErrTooManyGuests
+	}
+
+	// the usernames are unique, several guests may pick the same name
+	suffix := make([]byte, 3)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return repository.User{}, err
+	}
+
+	// no password, so that nobody can log in as the guest
+	u, err := r.userRepo.Insert(ctx, repository.User{
+		Username: fmt.Sprintf("%s#%x", name, suffix),
+		Guest:    true,
+	})
+	if err != nil {
+		return repository.User{}, err
+	}
+
+	err = r.repo.AddRetroMember(ctx, rid, u.ID, repository.RoleParticipant)
+	if err != nil {
+		return repository.User{}, err
+	}
+	return u, nil
+}
+
+// SetMemberRole changes the role of a member. Making a member facilitator
+// hands the facilitation over.
+func (r *retroService) SetMemberRole(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+	member int64,
+	role string,
+) error {
+	if role == repository.RoleFacilitator {
+		return r.HandOverFacilitation(ctx, rid, uid, member)
+	}
+	if role != repository.RoleParticipant && role != repository.RoleObserver {
+		return ErrInvalidRole
+	}
+
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return err
+	}
+	// compare the owner
+	if retro.UserID != uid {
+		return ErrNoAccess
+	}
+	if member == retro.UserID {
+		// the owner stays the owner
+		return ErrInvalidRole
+	}
+
+	err = r.repo.SetRetroMemberRole(ctx, rid, member, role)
+	if err == repository.ErrIDNotFound {
+		return ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.publishMembers(ctx, rid)
+}
+
+// HandOverFacilitation makes the member the facilitator of the retro, instead
+// of the current one. The owner and the facilitator may hand it over.
+func (r *retroService) HandOverFacilitation(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+	member int64,
+) error {
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return err
+	}
+	err = r.checkRole(ctx, retro, uid, facilitators)
+	if err != nil {
+		return err
+	}
+
+	err = r.checkMember(ctx, retro, member)
+	if err == ErrNoAccess {
+		return ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.repo.SetRetroFacilitator(ctx, rid, member)
+	if err != nil {
+		return err
+	}
+
+	return r.publishMembers(ctx, rid)
+}
+
+// publishMembers sends the members of the retro with their roles.
+func (r *retroService) publishMembers(ctx context.Context, rid int64) error {
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return err
+	}
+
+	r.publisher.Publish(ctx, Event{
+		Type:    EventMembersChanged,
+		RetroID: rid,
+		Members: retro.Members,
+	})
+	return nil
 }
 
 // }}}
@@ -162,8 +745,11 @@ type PostitCreate struct {
 }
 
 type PostitUpdate struct {
-	Content   string `json:"content"`
-	IsVisible bool   `json:"is_visible"`
+	// kept if not set, a move alone sends the question only
+	Content   *string `json:"content"`
+	IsVisible *bool   `json:"is_visible"`
+	// moves the post-it to another question of the retro if set
+	QuestionID int64 `json:"question_id"`
 }
 
 func (r *retroService) CreatePostit(
@@ -171,13 +757,37 @@ func (r *retroService) CreatePostit(
 	postit PostitCreate,
 	uid int64,
 ) (repository.Postit, error) {
+	retro, err := r.repo.GetRetroByQuestionID(ctx, postit.QuestionID)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+	err = r.checkRole(ctx, retro, uid, writers)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+	if retro.Phase != repository.PhaseWrite {
+		return repository.Postit{}, ErrWrongPhase
+	}
+
 	model := repository.Postit{
 		UserID:     uid,
 		QuestionID: postit.QuestionID,
 		Content:    postit.Content,
 		IsVisible:  postit.IsVisible,
 	}
-	return r.repo.CreatePostit(ctx, model)
+	p, err := r.repo.CreatePostit(ctx, model)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	// get the owner as well
+	p, err = r.repo.GetPostitByID(ctx, p.ID)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	r.publishPostit(ctx, EventPostitCreated, retro, p)
+	return p, nil
 }
 
 func (r *retroService) UpdatePostit(
@@ -190,32 +800,477 @@ func (r *retroService) UpdatePostit(
 	if err != nil {
 		return repository.Postit{}, err
 	}
-	// compare the owner
-	if p.UserID != uid {
-		return repository.Postit{}, ErrNoAccess
+
+	retro, err := r.repo.GetRetroByQuestionID(ctx, p.QuestionID)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	// the author edits the post-it while writing. The author and the
+	// facilitator move it while writing or grouping.
+	moving := postit.QuestionID != 0 && postit.QuestionID != p.QuestionID
+	author := p.UserID == uid
+	switch {
+	case author:
+		// an author made an observer only reads
+		err = r.checkRole(ctx, retro, uid, writers)
+	case moving:
+		err = r.checkRole(ctx, retro, uid, facilitators)
+	default:
+		err = ErrNoAccess
+	}
+	if err != nil {
+		return repository.Postit{}, err
+	}
+	switch {
+	case retro.Phase == repository.PhaseWrite:
+	case retro.Phase == repository.PhaseGroup && moving:
+	default:
+		return repository.Postit{}, ErrWrongPhase
+	}
+
+	if author && retro.Phase == repository.PhaseWrite {
+		if postit.Content != nil {
+			p.Content = *postit.Content
+		}
+		if postit.IsVisible != nil {
+			p.IsVisible = *postit.IsVisible
+		}
+	}
+
+	var oldGroup *int64
+	if moving {
+		q, err := r.repo.GetQuestionByID(ctx, postit.QuestionID)
+		if err != nil {
+			return repository.Postit{}, err
+		}
+		if q.RetroID != retro.ID {
+			return repository.Postit{}, ErrOtherRetro
+		}
+
+		// the groups are by question
+		oldGroup = p.GroupID
+		p.QuestionID = q.ID
+		p.GroupID = nil
+		p.GroupPosition = 0
+	}
+
+	p, err = r.repo.UpdatePostit(ctx, p)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+	if moving {
+		// at the bottom of the new question
+		_, err = r.repo.MovePostit(ctx, p.ID, 0, 0)
+		if err != nil {
+			return repository.Postit{}, err
+		}
+		p, err = r.repo.GetPostitByID(ctx, p.ID)
+		if err != nil {
+			return repository.Postit{}, err
+		}
 	}
 
-	p.Content = postit.Content
-	p.IsVisible = postit.IsVisible
+	r.publishPostit(ctx, EventPostitUpdated, retro, p)
+	if oldGroup != nil {
+		_, err = r.publishGroup(ctx, EventGroupUpdated, retro, *oldGroup, uid)
+		if err != nil {
+			return repository.Postit{}, err
+		}
+	}
 
-	return r.repo.UpdatePostit(ctx, p)
+	view := p
+	maskPostit(&view, uid, contentHidden(retro))
+	return view, nil
 }
 
+// DeletePostitByID deletes the post-it of the user, or any post-it for the
+// facilitator.
 func (r *retroService) DeletePostitByID(ctx context.Context, pid int64, uid int64) error {
 	p, err := r.repo.GetPostitByID(ctx, pid)
 	if err != nil {
 		return err
 	}
-	// compare the owner
+
+	retro, err := r.repo.GetRetroByQuestionID(ctx, p.QuestionID)
+	if err != nil {
+		return err
+	}
+	// compare the owner, who must still be a writer
+	roles := writers
 	if p.UserID != uid {
-		return ErrNoAccess
+		roles = facilitators
+	}
+	err = r.checkRole(ctx, retro, uid, roles)
+	if err != nil {
+		return err
+	}
+
+	err = r.repo.DeletePostitByID(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	r.publishPostit(ctx, EventPostitDeleted, retro, p)
+	return nil
+}
+
+func (r *retroService) VotePostitByID(ctx context.Context, pid int64, uid int64) error {
+	p, err := r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	retro, err := r.repo.GetRetroByQuestionID(ctx, p.QuestionID)
+	if err != nil {
+		return err
+	}
+	err = r.checkRole(ctx, retro, uid, writers)
+	if err != nil {
+		return err
+	}
+	if retro.Phase != repository.PhaseVote {
+		return ErrWrongPhase
+	}
+
+	if p.UserID == uid && !selfVoteAllowed(retro) {
+		return ErrSelfVote
+	}
+
+	err = r.repo.VotePostitByID(ctx, retro.ID, pid, uid, repository.VoteLimits{
+		Budget:    retro.VotesPerUser,
+		PerPostit: retro.MaxVotesPerPostit,
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.publishVotes(ctx, retro, pid)
+}
+
+// UnvotePostitByID takes back one vote of the user on the post-it.
+func (r *retroService) UnvotePostitByID(ctx context.Context, pid int64, uid int64) error {
+	p, err := r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	retro, err := r.repo.GetRetroByQuestionID(ctx, p.QuestionID)
+	if err != nil {
+		return err
+	}
+	err = r.checkRole(ctx, retro, uid, writers)
+	if err != nil {
+		return err
+	}
+	if retro.Phase != repository.PhaseVote {
+		return ErrWrongPhase
+	}
+
+	err = r.repo.UnvotePostitByID(ctx, pid, uid)
+	if err != nil {
+		return err
 	}
 
-	return r.repo.DeletePostitByID(ctx, pid)
+	return r.publishVotes(ctx, retro, pid)
 }
 
-func (r *retroService) VotePostitByID(ctx context.Context, pid int64) error {
-	return r.repo.VotePostitByID(ctx, pid)
+// Ways to merge the content of post-its
+const (
+	MergeKeep   = "keep"
+	MergeConcat = "concat"
+)
+
+type PostitMerge struct {
+	PostitIDs []int64 `json:"postit_ids" binding:"required,min=1"`
+	// keep the content of the target if not set
+	Mode string `json:"mode"`
+}
+
+// MergePostits folds duplicate post-its of the retro into the target. The
+// facilitator does it while grouping.
+func (r *retroService) MergePostits(
+	ctx context.Context,
+	pid int64,
+	merge PostitMerge,
+	uid int64,
+) (repository.Postit, error) {
+	target, err := r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	retro, err := r.repo.GetRetroByQuestionID(ctx, target.QuestionID)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+	err = r.checkRole(ctx, retro, uid, facilitators)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+	if retro.Phase != repository.PhaseGroup {
+		return repository.Postit{}, ErrWrongPhase
+	}
+
+	contents := []string{target.Content}
+	for i, id := range merge.PostitIDs {
+		if id == pid || slices.Contains(merge.PostitIDs[:i], id) {
+			return repository.Postit{}, ErrInvalidMerge
+		}
+		p, err := r.repo.GetPostitByID(ctx, id)
+		if err != nil {
+			return repository.Postit{}, err
+		}
+		if p.QuestionID != target.QuestionID {
+			// may be another retro
+			other, err := r.repo.GetRetroByQuestionID(ctx, p.QuestionID)
+			if err != nil {
+				return repository.Postit{}, err
+			}
+			if other.ID != retro.ID {
+				return repository.Postit{}, ErrInvalidMerge
+			}
+		}
+		contents = append(contents, p.Content)
+	}
+
+	var content string
+	switch merge.Mode {
+	case "", MergeKeep:
+	case MergeConcat:
+		content = strings.Join(contents, "\n\n")
+	default:
+		return repository.Postit{}, ErrInvalidMerge
+	}
+
+	err = r.repo.MergePostits(ctx, pid, merge.PostitIDs, content)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	target, err = r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	event := Event{
+		Type:          EventPostitsMerged,
+		RetroID:       retro.ID,
+		QuestionID:    target.QuestionID,
+		Postit:        &target,
+		MergedIDs:     merge.PostitIDs,
+		ContentHidden: contentHidden(retro),
+	}
+	err = r.hideVotes(ctx, retro, &event)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	r.publisher.Publish(ctx, event)
+	return *event.ViewFor(uid).Postit, nil
+}
+
+// PostitMove gives the new neighbours of the post-it in its question, 0 for
+// the top or the bottom. It goes at the bottom if none is set.
+type PostitMove struct {
+	After  int64 `json:"after"`
+	Before int64 `json:"before"`
+}
+
+// MovePostit changes the order of the post-it of the user, or of any post-it
+// for the facilitator, in its question, while writing or grouping.
+func (r *retroService) MovePostit(
+	ctx context.Context,
+	pid int64,
+	move PostitMove,
+	uid int64,
+) (repository.Postit, error) {
+	p, err := r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	retro, err := r.repo.GetRetroByQuestionID(ctx, p.QuestionID)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+	// compare the owner, who must still be a writer
+	roles := writers
+	if p.UserID != uid {
+		roles = facilitators
+	}
+	err = r.checkRole(ctx, retro, uid, roles)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+	if retro.Phase != repository.PhaseWrite && retro.Phase != repository.PhaseGroup {
+		return repository.Postit{}, ErrWrongPhase
+	}
+	if move.After == pid || move.Before == pid ||
+		(move.After != 0 && move.After == move.Before) {
+		return repository.Postit{}, ErrInvalidPosition
+	}
+
+	renumbered, err := r.repo.MovePostit(ctx, pid, move.After, move.Before)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	p, err = r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	event := Event{
+		Type:          EventPostitMoved,
+		RetroID:       retro.ID,
+		QuestionID:    p.QuestionID,
+		Postit:        &p,
+		ContentHidden: contentHidden(retro),
+	}
+	if renumbered {
+		// the others moved as well
+		event.Postits, err = r.repo.GetPostitsByQuestionID(ctx, p.QuestionID)
+		if err != nil {
+			return repository.Postit{}, err
+		}
+	}
+	err = r.hideVotes(ctx, retro, &event)
+	if err != nil {
+		return repository.Postit{}, err
+	}
+
+	r.publisher.Publish(ctx, event)
+	return *event.ViewFor(uid).Postit, nil
+}
+
+// publishVotes sends the new vote count of the post-it.
+func (r *retroService) publishVotes(ctx context.Context, retro repository.Retro, pid int64) error {
+	p, err := r.repo.GetPostitByID(ctx, pid)
+	if err != nil {
+		return err
+	}
+
+	r.publishPostit(ctx, EventPostitVoted, retro, p)
+	return nil
+}
+
+// MyVotes is what the user spent in a retro.
+type MyVotes struct {
+	// 0 for no limit
+	Budget int `json:"budget"`
+	Used   int `json:"used"`
+	// -1 for no limit
+	Remaining int               `json:"remaining"`
+	Votes     []repository.Vote `json:"votes"`
+}
+
+func (r *retroService) GetMyVotes(ctx context.Context, rid int64, uid int64) (MyVotes, error) {
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return MyVotes{}, err
+	}
+	err = r.checkMember(ctx, retro, uid)
+	if err != nil {
+		return MyVotes{}, err
+	}
+
+	votes, err := r.repo.GetUserVotes(ctx, rid, uid)
+	if err != nil {
+		return MyVotes{}, err
+	}
+
+	res := MyVotes{
+		Budget:    retro.VotesPerUser,
+		Used:      countVotes(votes),
+		Remaining: -1,
+		Votes:     votes,
+	}
+	if res.Budget > 0 {
+		res.Remaining = max(res.Budget-res.Used, 0)
+	}
+	return res, nil
+}
+
+func selfVoteAllowed(retro repository.Retro) bool {
+	return retro.AuthorizeSelfVote == nil || *retro.AuthorizeSelfVote
+}
+
+// votesOn returns the votes on the post-it.
+func votesOn(votes []repository.Vote, pid int64) int {
+	for _, v := range votes {
+		if v.PostitID == pid {
+			return v.Count
+		}
+	}
+	return 0
+}
+
+// groupVotesOn returns the votes on the group as a whole.
+func groupVotesOn(votes []repository.Vote, gid int64) int {
+	for _, v := range votes {
+		if v.GroupID == gid {
+			return v.Count
+		}
+	}
+	return 0
+}
+
+// totalVotes sums the votes of the group and of its post-its.
+func totalVotes(g repository.PostitGroup) int {
+	total := g.Votes
+	for _, p := range g.Postits {
+		total += p.Votes
+	}
+	return total
+}
+
+func countVotes(votes []repository.Vote) int {
+	count := 0
+	for _, v := range votes {
+		count += v.Count
+	}
+	return count
+}
+
+func (r *retroService) publishPostit(
+	ctx context.Context,
+	typ EventType,
+	retro repository.Retro,
+	p repository.Postit,
+) {
+	event := Event{
+		Type:          typ,
+		RetroID:       retro.ID,
+		QuestionID:    p.QuestionID,
+		Postit:        &p,
+		ContentHidden: contentHidden(retro),
+	}
+
+	err := r.hideVotes(ctx, retro, &event)
+	if err != nil {
+		slog.Error("event dropped", "type", typ, "retro", retro.ID, "err", err)
+		return
+	}
+
+	r.publisher.Publish(ctx, event)
+}
+
+// hideVotes gives the event what it needs to only show their own votes to the
+// users, if the retro hides the votes.
+func (r *retroService) hideVotes(ctx context.Context, retro repository.Retro, event *Event) error {
+	if !votesHidden(retro) {
+		return nil
+	}
+
+	votes, err := r.repo.GetRetroVotes(ctx, retro.ID)
+	if err != nil {
+		return err
+	}
+
+	event.VotesHidden = true
+	event.UserVotes, event.UserGroupVotes = userVotes(votes)
+	return nil
 }
 
 // }}}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/chenmuyao/qooldown/internal/repository"
+)
+
+// Actions on the timer of a retro
+const (
+	TimerStart  = "start"
+	TimerPause  = "pause"
+	TimerResume = "resume"
+	TimerAdd    = "add"
+	TimerStop   = "stop"
+)
+
+// TimerCommand is an action of the facilitator on the timer.
+type TimerCommand struct {
+	Action string `json:"action"`
+	// duration of start, or time added by add
+	Seconds int `json:"seconds"`
+	// move to the next phase when the countdown ends, for start
+	AutoAdvance bool `json:"auto_advance"`
+}
+
+// TimerState is the timer sent to the clients. They count down to the end
+// time given by the server, corrected by the offset of their clock.
+type TimerState struct {
+	repository.Timer
+	ServerTime time.Time `json:"server_time"`
+}
+
+func timerState(t repository.Timer) *TimerState {
+	return &TimerState{
+		Timer:      t,
+		ServerTime: time.Now(),
+	}
+}
+
+func (r *retroService) ControlTimer(
+	ctx context.Context,
+	rid int64,
+	uid int64,
+	cmd TimerCommand,
+) (repository.Timer, error) {
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		return repository.Timer{}, err
+	}
+	// the owner or the facilitator
+	err = r.checkRole(ctx, retro, uid, facilitators)
+	if err != nil {
+		return repository.Timer{}, err
+	}
+
+	t, err := nextTimer(retro.Timer, cmd, time.Now())
+	if err != nil {
+		return repository.Timer{}, err
+	}
+
+	err = r.repo.UpdateRetroTimer(ctx, rid, t)
+	if err != nil {
+		return repository.Timer{}, err
+	}
+
+	if t.TimerEndsAt != nil {
+		r.scheduleCountdown(rid, *t.TimerEndsAt)
+	} else {
+		r.cancelCountdown(rid)
+	}
+
+	r.publisher.Publish(ctx, Event{
+		Type:    EventTimerChanged,
+		RetroID: rid,
+		Timer:   timerState(t),
+	})
+	return t, nil
+}
+
+// nextTimer applies the command to the timer.
+func nextTimer(t repository.Timer, cmd TimerCommand, now time.Time) (repository.Timer, error) {
+	d := time.Duration(cmd.Seconds) * time.Second
+	// the database keeps milliseconds
+	now = now.Truncate(time.Millisecond)
+
+	switch cmd.Action {
+	case TimerStart:
+		if d <= 0 {
+			return t, ErrInvalidTimer
+		}
+		endsAt := now.Add(d)
+		return repository.Timer{
+			TimerEndsAt:      &endsAt,
+			TimerAutoAdvance: cmd.AutoAdvance,
+		}, nil
+	case TimerPause:
+		if t.TimerEndsAt == nil {
+			return t, ErrTimerState
+		}
+		remaining := max(t.TimerEndsAt.Sub(now), 0)
+		t.TimerEndsAt = nil
+		t.TimerRemainingMS = remaining.Milliseconds()
+		return t, nil
+	case TimerResume:
+		if t.TimerEndsAt != nil || t.TimerRemainingMS == 0 {
+			return t, ErrTimerState
+		}
+		endsAt := now.Add(time.Duration(t.TimerRemainingMS) * time.Millisecond)
+		t.TimerEndsAt = &endsAt
+		t.TimerRemainingMS = 0
+		return t, nil
+	case TimerAdd:
+		if d <= 0 {
+			return t, ErrInvalidTimer
+		}
+		switch {
+		case t.TimerEndsAt != nil:
+			endsAt := t.TimerEndsAt.Add(d)
+			t.TimerEndsAt = &endsAt
+		case t.TimerRemainingMS > 0:
+			t.TimerRemainingMS += d.Milliseconds()
+		default:
+			return t, ErrTimerState
+		}
+		return t, nil
+	case TimerStop:
+		return repository.Timer{}, nil
+	default:
+		return t, ErrInvalidTimer
+	}
+}
+
+// ResumeTimers schedules the end of the timers still running, after a
+// restart.
+func (r *retroService) ResumeTimers(ctx context.Context) error {
+	retros, err := r.repo.GetRetrosWithTimer(ctx)
+	if err != nil {
+		return err
+	}
+	for _, retro := range retros {
+		r.scheduleCountdown(retro.ID, *retro.TimerEndsAt)
+	}
+	return nil
+}
+
+// scheduleCountdown expires the timer of the retro at the end time, replacing
+// the previous countdown.
+func (r *retroService) scheduleCountdown(rid int64, endsAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.countdown[rid]; ok {
+		c.Stop()
+	}
+	r.countdown[rid] = time.AfterFunc(time.Until(endsAt), func() {
+		r.expireTimer(context.Background(), rid)
+	})
+}
+
+func (r *retroService) cancelCountdown(rid int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.countdown[rid]; ok {
+		c.Stop()
+		delete(r.countdown, rid)
+	}
+}
+
+// expireTimer stops the timer of the retro once it is over, and moves to the
+// next phase if asked.
+func (r *retroService) expireTimer(ctx context.Context, rid int64) {
+	r.mu.Lock()
+	delete(r.countdown, rid)
+	r.mu.Unlock()
+
+	retro, err := r.repo.GetRetroByID(ctx, rid)
+	if err != nil {
+		slog.Error("expire timer", "retro", rid, "err", err)
+		return
+	}
+
+	expired, err := r.repo.ExpireRetroTimer(ctx, rid, time.Now())
+	if err != nil {
+		slog.Error("expire timer", "retro", rid, "err", err)
+		return
+	}
+	if !expired {
+		// changed in the meantime, or expired by another instance
+		return
+	}
+
+	r.publisher.Publish(ctx, Event{
+		Type:    EventTimerChanged,
+		RetroID: rid,
+		Timer:   timerState(repository.Timer{}),
+	})
+
+	next := phaseIndex(retro.Phase) + 1
+	if !retro.TimerAutoAdvance || next <= 0 || next >= len(phases) {
+		return
+	}
+	err = r.setPhase(ctx, retro, phases[next])
+	if err != nil {
+		slog.Error("advance phase", "retro", rid, "err", err)
+	}
+}
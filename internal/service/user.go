@@ -16,6 +16,7 @@ var (
 type UserService interface {
 	SignUp(ctx context.Context, u repository.User) (repository.User, error)
 	Login(ctx context.Context, username string, password string) (repository.User, error)
+	GetUserByID(ctx context.Context, uid int64) (repository.User, error)
 }
 
 type userService struct {
@@ -57,3 +58,7 @@ func (svc *userService) Login(
 	}
 	return u, nil
 }
+
+func (svc *userService) GetUserByID(ctx context.Context, uid int64) (repository.User, error) {
+	return svc.repo.FindByID(ctx, uid)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/chenmuyao/qooldown/internal/service"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -19,11 +21,26 @@ import (
 func main() {
 	db := InitDB()
 
+	hub := InitWsHub()
+	userRepo := repository.NewUserRepository(db)
+	userSvc := service.NewUserService(userRepo)
+	retroSvc := service.NewRetroService(repository.NewRetroRepository(db), userRepo, hub)
+	err := retroSvc.ResumeTimers(context.Background())
+	if err != nil {
+		slog.Error("resume timers", "err", err)
+		panic("failed to resume timers")
+	}
+	wsHandler := handler.NewWebSocketHandler(retroSvc, userSvc, hub, handler.WsLimits{
+		MaxMessageSize: config.Config.WS.MaxMessageSize,
+		Rate:           config.Config.WS.RateLimit,
+		Burst:          config.Config.WS.RateBurst,
+	})
+
 	server := InitWebServer(
 		InitGinMiddlewares(),
-		handler.NewUserHandler(service.NewUserService(repository.NewUserRepository(db))),
-		handler.NewWebSocketHandler(),
-		handler.NewRetroHandler(service.NewRetroService(repository.NewRetroRepository(db))),
+		handler.NewUserHandler(userSvc),
+		wsHandler,
+		handler.NewRetroHandler(retroSvc),
 	)
 
 	server.GET(
@@ -67,10 +84,31 @@ func useJWT() gin.HandlerFunc {
 		"/ws",
 		"/users/signup",
 		"/users/login",
-	})
+		"/guests/join",
+	}, handler.GuestRoutes)
 	return loginJWT.CheckLogin()
 }
 
+func InitWsHub() *handler.WsHub {
+	var broker handler.Broker
+	switch config.Config.Broker.Type {
+	case config.BrokerRedis:
+		broker = handler.NewRedisBroker(redis.NewClient(&redis.Options{
+			Addr: config.Config.Broker.RedisAddr,
+		}))
+	default:
+		broker = handler.NewMemoryBroker()
+	}
+
+	hub := handler.NewWsHub(broker)
+	go func() {
+		err := hub.Run(context.Background())
+		slog.Error("ws hub stopped", "err", err)
+		panic("ws hub stopped")
+	}()
+	return hub
+}
+
 func InitDB() *gorm.DB {
 	var db *gorm.DB
 	var err error
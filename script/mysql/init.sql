@@ -1 +1,2 @@
 CREATE DATABASE qooldown;
+CREATE DATABASE qooldown_test;